@@ -0,0 +1,67 @@
+package log_hooks
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// parseAddress parses a validated address such as "Alerts <alerts@example.com>".
+func parseAddress(address string) *mail.Address {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return &mail.Address{Address: address}
+	}
+	return parsed
+}
+
+// headerAddress formats an address for the headers, encoding a UTF-8 display name.
+// Bare addresses are kept as they are.
+func headerAddress(address *mail.Address) string {
+	if address.Name == "" {
+		return address.Address
+	}
+	return address.String()
+}
+
+// addressBook holds the parsed static addresses of a hook, so a send doesn't parse them again.
+type addressBook map[string]*mail.Address
+
+// newAddressBook parses the validated addresses of the lists.
+func newAddressBook(lists ...[]string) addressBook {
+	book := make(addressBook)
+	for _, list := range lists {
+		for _, address := range list {
+			if _, ok := book[address]; !ok {
+				book[address] = parseAddress(address)
+			}
+		}
+	}
+	return book
+}
+
+// parse returns the parsed address. Addresses missing from the book, those of a
+// RecipientFunc, are parsed on every call.
+func (b addressBook) parse(address string) *mail.Address {
+	if parsed, ok := b[address]; ok {
+		return parsed
+	}
+	return parseAddress(address)
+}
+
+// header formats a list of validated addresses for the headers.
+func (b addressBook) header(addresses []string) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = headerAddress(b.parse(address))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// envelope strips the display names off validated addresses for MAIL FROM and RCPT TO.
+func (b addressBook) envelope(addresses []string) []string {
+	bare := make([]string, len(addresses))
+	for i, address := range addresses {
+		bare[i] = b.parse(address).Address
+	}
+	return bare
+}
@@ -0,0 +1,77 @@
+package log_hooks
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDisplayNames(t *testing.T) {
+	server := newTestServer(t, nil)
+	recipients := []string{"ops@example.com", "Дежурный <oncall@example.com>", `"Ops, Team" <team@example.com>`}
+	hook, err := NewMailHookMulti("app", "127.0.0.1", server.port(), "Alerts <alerts@example.com>", recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "display names")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The envelope carries the bare addresses.
+	var envelope []string
+	for _, command := range server.commandLog() {
+		if strings.HasPrefix(command, "MAIL FROM:") || strings.HasPrefix(command, "RCPT TO:") {
+			command, _, _ = strings.Cut(command, " BODY=")
+			envelope = append(envelope, command)
+		}
+	}
+	want := []string{"MAIL FROM:<alerts@example.com>", "RCPT TO:<ops@example.com>", "RCPT TO:<oncall@example.com>", "RCPT TO:<team@example.com>"}
+	if strings.Join(envelope, "|") != strings.Join(want, "|") {
+		t.Fatalf("got envelope %q, want %q", envelope, want)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := parseMessage(t, messages[0])
+	from, err := msg.Header.AddressList("From")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(from) != 1 || *from[0] != (mail.Address{Name: "Alerts", Address: "alerts@example.com"}) {
+		t.Fatalf("got From %v", from)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTo := []mail.Address{{Address: "ops@example.com"}, {Name: "Дежурный", Address: "oncall@example.com"}, {Name: "Ops, Team", Address: "team@example.com"}}
+	if len(to) != len(wantTo) {
+		t.Fatalf("got To %v", to)
+	}
+	for i := range to {
+		if *to[i] != wantTo[i] {
+			t.Fatalf("got To %v, want %v", to, wantTo)
+		}
+	}
+	// The UTF-8 name is encoded, the bare address kept as is.
+	if header := msg.Header.Get("To"); !strings.HasPrefix(header, "ops@example.com, =?utf-8?") {
+		t.Fatalf("got To header %q", header)
+	}
+}
+
+func TestInvalidAddresses(t *testing.T) {
+	for _, address := range []string{"", "not an address", "Alerts <alerts@example.com"} {
+		_, err := NewMailHook("app", "127.0.0.1", 25, address, "ops@example.com", WithoutConnectivityCheck())
+		if err == nil {
+			t.Errorf("sender %q accepted", address)
+		}
+		_, err = NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", address, WithoutConnectivityCheck())
+		if err == nil {
+			t.Errorf("recipient %q accepted", address)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueuePolicy decides what happens when the async queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes Fire wait until there is room in the queue, at most for the
+	// block timeout when one is set, see WithQueueBlockTimeout.
+	QueueBlock QueuePolicy = iota
+	// QueueDropNew discards the entry being fired.
+	QueueDropNew
+	// QueueDropOldest discards the oldest queued message to make room.
+	QueueDropOldest
+)
+
+// WithAsync makes Fire only format the message and queue it, a background worker
+// does the SMTP conversation. Call Flush before exiting so queued emails aren't lost.
+// Panic and Fatal entries are still sent by Fire, a Fatal one also waits for the queue
+// at most for the close timeout, since logrus exits right after it.
+func WithAsync(queueSize int, policy QueuePolicy) MailOption {
+	return func(o *mailOptions) error {
+		if queueSize <= 0 {
+			return fmt.Errorf("queue size must be positive, got %d", queueSize)
+		}
+		switch policy {
+		case QueueBlock, QueueDropNew, QueueDropOldest:
+		default:
+			return fmt.Errorf("unknown queue policy %d", policy)
+		}
+		o.async = true
+		o.queueSize = queueSize
+		o.queuePolicy = policy
+		return nil
+	}
+}
+
+// ErrQueueFull is passed to the error handler, see WithOnError, for every email discarded
+// because the async queue was full.
+var ErrQueueFull = errors.New("mail hook queue is full")
+
+// WithQueueBlockTimeout bounds how long Fire waits for room in the queue with QueueBlock,
+// the entry is discarded afterwards.
+func WithQueueBlockTimeout(timeout time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if timeout <= 0 {
+			return fmt.Errorf("queue block timeout must be positive, got %s", timeout)
+		}
+		o.queueBlockTimeout = timeout
+		return nil
+	}
+}
+
+// Flush blocks until all queued emails are sent or ctx is done.
+func (m *mailer) Flush(ctx context.Context) error {
+	if m.queue == nil {
+		return nil
+	}
+	return m.queue.flush(ctx)
+}
+
+// sendQueue is a bounded queue of rendered messages drained by one worker.
+type sendQueue struct {
+	messages chan outgoing
+	policy   QueuePolicy
+	send     func(message outgoing) error
+	// drop is called for every discarded message.
+	drop func(message outgoing)
+	// blockTimeout bounds the waits of QueueBlock, zero waits forever.
+	blockTimeout time.Duration
+
+	// closeMu keeps push from racing with close.
+	closeMu sync.RWMutex
+	closed  bool
+
+	pendingMu sync.Mutex
+	pending   int
+	drained   chan struct{}
+
+	done chan struct{}
+
+	// dropped counts messages discarded because the queue was full.
+	dropped atomic.Int64
+}
+
+func newSendQueue(size int, policy QueuePolicy, blockTimeout time.Duration, send func(message outgoing) error, drop func(message outgoing)) *sendQueue {
+	drained := make(chan struct{})
+	close(drained)
+
+	q := &sendQueue{
+		messages:     make(chan outgoing, size),
+		policy:       policy,
+		send:         send,
+		drop:         drop,
+		blockTimeout: blockTimeout,
+		drained:      drained,
+		done:         make(chan struct{}),
+	}
+	go q.work()
+	return q
+}
+
+func (q *sendQueue) work() {
+	defer close(q.done)
+	for message := range q.messages {
+		// send reports its errors itself, Fire has already returned.
+		_ = q.send(message)
+		q.finish()
+	}
+}
+
+func (q *sendQueue) push(message outgoing) {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		return
+	}
+
+	q.start()
+	switch q.policy {
+	case QueueDropNew:
+		select {
+		case q.messages <- message:
+		default:
+			q.discard(message)
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case q.messages <- message:
+				return
+			default:
+			}
+			select {
+			case oldest := <-q.messages:
+				q.discard(oldest)
+			default:
+			}
+		}
+	default:
+		if q.blockTimeout == 0 {
+			q.messages <- message
+			return
+		}
+		timer := time.NewTimer(q.blockTimeout)
+		defer timer.Stop()
+		select {
+		case q.messages <- message:
+		case <-timer.C:
+			q.discard(message)
+		}
+	}
+}
+
+// discard drops a message that was counted as pending.
+func (q *sendQueue) discard(message outgoing) {
+	q.dropped.Add(1)
+	q.finish()
+	if message.failed != nil {
+		message.failed()
+	}
+	if q.drop != nil {
+		q.drop(message)
+	}
+}
+
+func (q *sendQueue) start() {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	if q.pending == 0 {
+		q.drained = make(chan struct{})
+	}
+	q.pending++
+}
+
+func (q *sendQueue) finish() {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		close(q.drained)
+	}
+}
+
+func (q *sendQueue) flush(ctx context.Context) error {
+	q.pendingMu.Lock()
+	drained := q.drained
+	q.pendingMu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting messages and waits until the queued ones are sent or ctx is done.
+func (q *sendQueue) close(ctx context.Context) error {
+	q.closeMu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+	q.closeMu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.pendingMu.Lock()
+		pending := q.pending
+		q.pendingMu.Unlock()
+		return fmt.Errorf("%d queued email(s) not sent: %w", pending, ctx.Err())
+	}
+}
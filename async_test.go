@@ -0,0 +1,214 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newAsyncHook creates a hook sending to the server in the background.
+func newAsyncHook(t *testing.T, server *testServer, opts ...MailOption) *MailHook {
+	t.Helper()
+	opts = append([]MailOption{WithoutConnectivityCheck(), WithoutDedup()}, opts...)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+var messageLine = regexp.MustCompile(`MESSAGE: (\S+)`)
+
+// sentMessages returns the log messages of the emails the server accepted, in order.
+func sentMessages(t *testing.T, server *testServer) []string {
+	t.Helper()
+	var messages []string
+	for _, raw := range server.received() {
+		body := decodedBody(t, parseMessage(t, raw))
+		match := messageLine.FindStringSubmatch(body)
+		if match == nil {
+			t.Fatalf("no message in %q", body)
+		}
+		messages = append(messages, match[1])
+	}
+	return messages
+}
+
+func TestAsync(t *testing.T) {
+	hold := make(chan struct{})
+	server := newTestServer(t, func(s *testServer) { s.hold = hold })
+	hook := newAsyncHook(t, server, WithAsync(10, QueueBlock))
+	defer func() { _ = hook.Close() }()
+
+	// Fire returns while the server still holds the email.
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "queued")); err != nil {
+		t.Fatal(err)
+	}
+	<-hold
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hook.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("flush with the email in flight: got %v", err)
+	}
+	hold <- struct{}{}
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if messages := sentMessages(t, server); !slices.Equal(messages, []string{"queued"}) {
+		t.Fatalf("got messages %q", messages)
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy QueuePolicy
+		want   []string
+	}{
+		{QueueDropNew, []string{"first", "second"}},
+		{QueueDropOldest, []string{"first", "third"}},
+		{QueueBlock, []string{"first", "second", "third"}},
+	} {
+		hold := make(chan struct{})
+		server := newTestServer(t, func(s *testServer) { s.hold = hold })
+		hook := newAsyncHook(t, server, WithAsync(1, test.policy))
+
+		// The worker holds the first email, the second fills the queue.
+		for _, message := range []string{"first", "second"} {
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+				t.Fatal(err)
+			}
+			if message == "first" {
+				<-hold
+			}
+		}
+		fired := make(chan error, 1)
+		go func() { fired <- hook.Fire(testEntry(logrus.ErrorLevel, "third")) }()
+		if test.policy == QueueBlock {
+			select {
+			case <-fired:
+				t.Fatal("Fire returned with a full queue")
+			case <-time.After(50 * time.Millisecond):
+			}
+		} else if err := <-fired; err != nil {
+			t.Fatal(err)
+		}
+
+		hold <- struct{}{}
+		for range test.want[1:] {
+			<-hold
+			hold <- struct{}{}
+		}
+		if err := hook.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if test.policy == QueueBlock {
+			if err := <-fired; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if messages := sentMessages(t, server); !slices.Equal(messages, test.want) {
+			t.Fatalf("policy %d: got messages %q, want %q", test.policy, messages, test.want)
+		}
+	}
+}
+
+func TestQueueBlockTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	server := newTestServer(t, func(s *testServer) { s.hold = hold })
+	var mu sync.Mutex
+	var dropped []string
+	hook := newAsyncHook(t, server, WithAsync(1, QueueBlock), WithQueueBlockTimeout(50*time.Millisecond),
+		WithOnError(func(entry *logrus.Entry, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, ErrQueueFull) {
+				dropped = append(dropped, entry.Message)
+			}
+		}))
+	defer func() { _ = hook.Close() }()
+
+	for _, message := range []string{"first", "second", "third"} {
+		start := time.Now()
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+		if message == "first" {
+			<-hold
+		}
+		if message == "third" && time.Since(start) < 50*time.Millisecond {
+			t.Fatalf("Fire returned after %s, before the block timeout", time.Since(start))
+		}
+	}
+	hold <- struct{}{}
+	<-hold
+	hold <- struct{}{}
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := sentMessages(t, server); !slices.Equal(messages, []string{"first", "second"}) {
+		t.Fatalf("got messages %q", messages)
+	}
+	if n := hook.Stats().Dropped; n != 1 {
+		t.Fatalf("got %d dropped, want 1", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(dropped, []string{"third"}) {
+		t.Fatalf("got ErrQueueFull for %q", dropped)
+	}
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook := newAsyncHook(t, server, WithAsync(10, QueueBlock))
+	for _, message := range []string{"first", "second", "third"} {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if messages := sentMessages(t, server); !slices.Equal(messages, []string{"first", "second", "third"}) {
+		t.Fatalf("got messages %q", messages)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	server := newTestServer(t, func(s *testServer) { s.hold = hold })
+	hook := newAsyncHook(t, server, WithAsync(10, QueueBlock), WithCloseTimeout(50*time.Millisecond))
+
+	for _, message := range []string{"first", "second"} {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-hold
+	err := hook.Close()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "2 queued email(s) not sent") {
+		t.Fatalf("got %v, want the emails still queued", err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+
+	// The worker goes on with the queued emails after Close gave up.
+	hold <- struct{}{}
+	<-hold
+	hold <- struct{}{}
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if messages := sentMessages(t, server); !slices.Equal(messages, []string{"first", "second"}) {
+		t.Fatalf("got messages %q", messages)
+	}
+}
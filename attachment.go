@@ -0,0 +1,100 @@
+package log_hooks
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// WithAttachments sends entry data and the stack trace as data.json and stack.txt attachments
+// instead of inlining them. With a positive threshold they are attached only when their
+// combined size exceeds threshold bytes, with zero they are always attached.
+func WithAttachments(threshold int) MailOption {
+	return func(o *mailOptions) error {
+		if threshold < 0 {
+			return fmt.Errorf("attachment threshold must not be negative, got %d", threshold)
+		}
+		o.attach = true
+		o.attachThreshold = threshold
+		return nil
+	}
+}
+
+func (m *mailer) shouldAttach(data BodyData) bool {
+	if m.attachesDump(data) {
+		return true
+	}
+	if !m.options.attach {
+		return false
+	}
+	if m.options.attachThreshold == 0 {
+		return true
+	}
+	return len(jsonIndent(data.Data))+len(data.Stack) > m.options.attachThreshold
+}
+
+// mixedBody renders a multipart/mixed body with a short summary followed by the attachments.
+func (m *mailer) mixedBody(data BodyData) mimePart {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	summary := "TIME: " + data.FormattedTime + "\n" +
+		fmt.Sprintf("HOST: %s (pid %d)\n", data.Hostname, data.PID)
+	if data.Environment != "" {
+		summary += "ENV: " + data.Environment + "\n"
+	}
+	summary += "GO: " + data.GoVersion + "\n"
+	if data.Build != "" {
+		summary += "BUILD: " + data.Build + "\n"
+	}
+	summary += "LEVEL: " + data.Level + "\n" +
+		"MESSAGE: " + data.Message + "\n"
+	if data.Caller != "" {
+		summary += "CALLER: " + data.Caller + "\n"
+	}
+	for _, note := range data.Notes {
+		summary += "NOTE: " + note + "\n"
+	}
+	summary += fmt.Sprintf("\n%d field(s) attached as data.json", len(data.Data))
+	if len(data.History) > 0 {
+		summary += fmt.Sprintf(", %d history line(s) attached as history.txt", len(data.History))
+	}
+	if data.Stack != "" {
+		summary += ", stack trace attached as " + stackFile(data)
+	}
+	summary += ".\n"
+	part := textPart("text/plain; charset=utf-8", summary)
+	w, _ := writer.CreatePart(part.header())
+	_, _ = io.WriteString(w, part.body)
+
+	writeAttachment(writer, "data.json", "application/json", []byte(jsonIndent(data.Data)))
+	if len(data.History) > 0 {
+		writeAttachment(writer, "history.txt", "text/plain; charset=utf-8", []byte(strings.Join(data.History, "\n")+"\n"))
+	}
+	if data.Stack != "" {
+		writeAttachment(writer, stackFile(data), "text/plain; charset=utf-8", []byte(data.Stack))
+	}
+	_ = writer.Close()
+
+	return mimePart{contentType: "multipart/mixed; boundary=" + writer.Boundary(), body: buf.String()}
+}
+
+func writeAttachment(writer *multipart.Writer, name string, contentType string, content []byte) {
+	w, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {`attachment; filename="` + name + `"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+
+	// RFC 2045 limits encoded lines to 76 characters.
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		_, _ = io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	_, _ = io.WriteString(w, encoded+"\r\n")
+}
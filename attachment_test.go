@@ -0,0 +1,50 @@
+package log_hooks
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAttachments(t *testing.T) {
+	large := strings.Repeat("SELECT 1; ", 2000)
+	small := testEntry(logrus.ErrorLevel, "fast query")
+	small.Data["query"] = "SELECT 1"
+	inline := fireMessage(t, newDryRunHook(t, WithAttachments(10000)), small)
+	if contentType := inline.Header.Get("Content-Type"); strings.HasPrefix(contentType, "multipart/") {
+		t.Fatalf("entry under the threshold got Content-Type %q", contentType)
+	}
+
+	entry := testEntry(logrus.ErrorLevel, "slow query")
+	entry.Data["query"] = large
+	parts := multipartBody(t, fireMessage(t, newDryRunHook(t, WithAttachments(10000)), entry), "multipart/mixed")
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, want the summary, data.json and stack.txt", len(parts))
+	}
+	summary := parts[0].body
+	if strings.Contains(summary, large) || !strings.Contains(summary, "MESSAGE: slow query") ||
+		!strings.Contains(summary, "1 field(s) attached as data.json, stack trace attached as stack.txt.") {
+		t.Fatalf("got summary:\n%s", summary)
+	}
+	for i, want := range []string{`attachment; filename="data.json"`, `attachment; filename="stack.txt"`} {
+		if got := parts[i+1].header.Get("Content-Disposition"); got != want {
+			t.Fatalf("part %d: got Content-Disposition %q, want %q", i+2, got, want)
+		}
+	}
+	var data map[string]string
+	if err := json.Unmarshal([]byte(parts[1].body), &data); err != nil || data["query"] != large {
+		t.Fatalf("got data.json %q: %v", parts[1].body, err)
+	}
+	if !strings.Contains(parts[2].body, "goroutine ") {
+		t.Fatalf("got stack.txt %q", parts[2].body)
+	}
+
+	// Zero attaches whatever the size.
+	multipartBody(t, fireMessage(t, newDryRunHook(t, WithAttachments(0)), small), "multipart/mixed")
+	if _, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithAttachments(-1)); err == nil {
+		t.Fatal("negative threshold accepted")
+	}
+}
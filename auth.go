@@ -0,0 +1,251 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// ErrAuthMechanismNotSupported is returned when the server doesn't advertise the requested AUTH mechanism.
+var ErrAuthMechanismNotSupported = errors.New("smtp server doesn't support auth mechanism")
+
+// AuthMechanism is the SMTP AUTH mechanism used by MailAuthHook.
+type AuthMechanism string
+
+const (
+	// AuthPlain uses PLAIN authentication. This is the default.
+	AuthPlain AuthMechanism = "PLAIN"
+	// AuthCRAMMD5 uses CRAM-MD5 challenge-response authentication.
+	AuthCRAMMD5 AuthMechanism = "CRAM-MD5"
+	// AuthXOAuth2 uses the XOAUTH2 SASL exchange with a bearer token, see WithXOAuth2.
+	AuthXOAuth2 AuthMechanism = "XOAUTH2"
+	// AuthLogin uses the non-standard LOGIN mechanism (Exchange and friends).
+	AuthLogin AuthMechanism = "LOGIN"
+	// AuthAuto picks the strongest mechanism advertised by the server.
+	AuthAuto AuthMechanism = "auto"
+)
+
+// autoMechanisms lists mechanisms in order of preference for AuthAuto.
+var autoMechanisms = []AuthMechanism{AuthXOAuth2, AuthCRAMMD5, AuthPlain, AuthLogin}
+
+// WithAuthMechanism sets the SMTP AUTH mechanism used by MailAuthHook.
+func WithAuthMechanism(mechanism AuthMechanism) MailOption {
+	return func(o *mailOptions) error {
+		switch mechanism {
+		case AuthPlain, AuthCRAMMD5, AuthXOAuth2, AuthLogin, AuthAuto:
+			o.authMechanism = mechanism
+			return nil
+		}
+		return fmt.Errorf("unknown smtp auth mechanism %q", mechanism)
+	}
+}
+
+// WithXOAuth2 makes MailAuthHook authenticate with XOAUTH2 (Gmail, Office365).
+// tokenSource is called on every send and must return a valid OAuth2 access token,
+// e.g. from golang.org/x/oauth2. The password passed to NewMailAuthHook is not used.
+func WithXOAuth2(tokenSource func() (string, error)) MailOption {
+	return func(o *mailOptions) error {
+		if tokenSource == nil {
+			return errors.New("xoauth2 token source must not be nil")
+		}
+		o.authMechanism = AuthXOAuth2
+		o.tokenSource = tokenSource
+		return nil
+	}
+}
+
+// WithAllowInsecureAuth allows the LOGIN mechanism over an unencrypted connection.
+func WithAllowInsecureAuth() MailOption {
+	return func(o *mailOptions) error {
+		o.allowInsecureAuth = true
+		return nil
+	}
+}
+
+// checkCredentials validates the credentials required by the configured mechanism.
+func checkCredentials(username string, password string, options mailOptions) error {
+	if options.authMechanism == AuthXOAuth2 {
+		if username == "" || options.tokenSource == nil {
+			return ErrEmptyCredentials
+		}
+		return nil
+	}
+	if username == "" || (password == "" && options.tokenSource == nil) {
+		return ErrEmptyCredentials
+	}
+	return nil
+}
+
+// checkInsecureAuth warns, or fails with WithStrictTLSAuth, when a password would be sent
+// in plaintext over a connection whose certificate isn't verified.
+func (m *mailer) checkInsecureAuth() error {
+	if !m.options.tlsConfig.InsecureSkipVerify {
+		return nil
+	}
+	switch m.options.authMechanism {
+	case "", AuthPlain, AuthLogin, AuthAuto:
+	default:
+		return nil
+	}
+
+	for _, ep := range m.endpoints {
+		if ep.username == "" || ep.password == "" {
+			continue
+		}
+		err := fmt.Errorf("%w: endpoint %s", ErrInsecureTLSAuth, ep)
+		if m.options.strictTLSAuth {
+			return err
+		}
+		m.reportError(nil, err)
+	}
+	return nil
+}
+
+// authenticate upgrades the connection if possible and logs in to the endpoint.
+func (m *mailer) authenticate(s *session, ep *endpoint) error {
+	client := s.Client
+	// Upgrade opportunistically like smtp.SendMail does, so credentials aren't sent in plaintext.
+	if !m.options.startTLS && !m.options.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(ep.tlsConfig); err != nil {
+				return clientAuthError(err)
+			}
+			s.trace.wrapClient(client)
+		}
+	}
+
+	ok, advertised := client.Extension("AUTH")
+	if !ok {
+		return ErrAuthNotSupported
+	}
+
+	auth, err := m.auth(ep, advertised)
+	if err != nil {
+		return err
+	}
+	if err := client.Auth(auth); err != nil {
+		return authError(ep.username, auth, err)
+	}
+	return nil
+}
+
+// auth chooses the smtp.Auth for the mechanisms advertised by the server.
+func (m *mailer) auth(ep *endpoint, advertised string) (smtp.Auth, error) {
+	mechanism := m.options.authMechanism
+	if mechanism == "" {
+		mechanism = AuthPlain
+	}
+
+	supported := strings.Fields(strings.ToUpper(advertised))
+	if mechanism == AuthAuto {
+		for _, candidate := range autoMechanisms {
+			if candidate == AuthXOAuth2 && m.options.tokenSource == nil {
+				continue
+			}
+			if candidate != AuthXOAuth2 && ep.password == "" {
+				continue
+			}
+			if containsString(supported, string(candidate)) {
+				return m.authFor(ep, candidate), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: none of %v in %q", ErrAuthMechanismNotSupported, autoMechanisms, advertised)
+	}
+
+	if !containsString(supported, string(mechanism)) {
+		return nil, fmt.Errorf("%w: %s not in %q", ErrAuthMechanismNotSupported, mechanism, advertised)
+	}
+	return m.authFor(ep, mechanism), nil
+}
+
+func (m *mailer) authFor(ep *endpoint, mechanism AuthMechanism) smtp.Auth {
+	switch mechanism {
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(ep.username, ep.password)
+	case AuthXOAuth2:
+		return &xoauth2Auth{username: ep.username, tokenSource: m.options.tokenSource}
+	case AuthLogin:
+		return &loginAuth{username: ep.username, password: ep.password, allowInsecure: m.options.allowInsecureAuth}
+	default:
+		return smtp.PlainAuth("", ep.username, ep.password, ep.host)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// xoauth2Auth implements smtp.Auth for the XOAUTH2 mechanism.
+type xoauth2Auth struct {
+	username    string
+	tokenSource func() (string, error)
+	serverError string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Same rule as smtp.PlainAuth: never send the token over an unencrypted connection.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("xoauth2 requires an encrypted connection")
+	}
+
+	token, err := a.tokenSource()
+	if err != nil {
+		return "", nil, fmt.Errorf("xoauth2 token source: %w", err)
+	}
+	return string(AuthXOAuth2), []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// On failure the server sends a 334 with a JSON error description and expects
+		// an empty response before the final 535.
+		a.serverError = string(fromServer)
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// loginAuth implements smtp.Auth for the LOGIN mechanism, which the standard library lacks.
+type loginAuth struct {
+	username      string
+	password      string
+	allowInsecure bool
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !a.allowInsecure && !isLocalhost(server.Name) {
+		return "", nil, errors.New("login auth over an unencrypted connection requires WithAllowInsecureAuth")
+	}
+	return string(AuthLogin), nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected login auth challenge %q", fromServer)
+}
+
+// authError adds the details the server sent during the exchange to a failed auth error.
+func authError(username string, auth smtp.Auth, err error) error {
+	if x, ok := auth.(*xoauth2Auth); ok && x.serverError != "" {
+		return fmt.Errorf("smtp authentication as %q failed: %w (server: %s)", username, err, x.serverError)
+	}
+	return fmt.Errorf("smtp authentication as %q failed: %w", username, err)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,232 @@
+package log_hooks
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newAuthHook(t *testing.T, server *testServer, username string, password string, opts ...MailOption) *MailAuthHook {
+	t.Helper()
+	hook, err := NewMailAuthHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", username, password, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = hook.Close() })
+	return hook
+}
+
+func TestMailAuthHookPlain(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	hook := newAuthHook(t, server, "alerts", "secret")
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "authenticated")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestMailAuthHookPlainFailed(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	hook := newAuthHook(t, server, "alerts", "wrong")
+
+	err := hook.Fire(testEntry(logrus.ErrorLevel, "denied"))
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 535 {
+		t.Fatalf("got %v, want the 535 reply", err)
+	}
+	if !strings.Contains(err.Error(), `smtp authentication as "alerts" failed`) {
+		t.Fatalf("error %q doesn't name the user", err)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages, want none", n)
+	}
+}
+
+func TestMailAuthHookNotSupported(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook := newAuthHook(t, server, "alerts", "secret")
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "no auth")); !errors.Is(err, ErrAuthNotSupported) {
+		t.Fatalf("got %v, want ErrAuthNotSupported", err)
+	}
+}
+
+func TestNewMailAuthHookEmptyCredentials(t *testing.T) {
+	for _, credentials := range [][2]string{{"", "secret"}, {"alerts", ""}} {
+		_, err := NewMailAuthHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+			credentials[0], credentials[1], WithoutConnectivityCheck())
+		if !errors.Is(err, ErrEmptyCredentials) {
+			t.Fatalf("%q: got %v, want ErrEmptyCredentials", credentials, err)
+		}
+	}
+}
+
+func TestXOAuth2(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.tokens = map[string]string{"alerts@example.com": "ya29.token"}
+	})
+	hook := newAuthHook(t, server, "alerts@example.com", "", WithXOAuth2(func() (string, error) { return "ya29.token", nil }))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "oauth")); err != nil {
+		t.Fatal(err)
+	}
+	// The token goes in the initial response.
+	initial := base64.StdEncoding.EncodeToString([]byte("user=alerts@example.com\x01auth=Bearer ya29.token\x01\x01"))
+	if !slices.Contains(server.commandLog(), "AUTH XOAUTH2 "+initial) {
+		t.Fatalf("no initial response in %q", server.commandLog())
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestXOAuth2Rejected(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.tokens = map[string]string{"alerts@example.com": "ya29.token"}
+	})
+	hook := newAuthHook(t, server, "alerts@example.com", "", WithXOAuth2(func() (string, error) { return "expired", nil }))
+
+	err := hook.Fire(testEntry(logrus.ErrorLevel, "expired token"))
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 535 {
+		t.Fatalf("got %v, want the 535 reply", err)
+	}
+	// The 334 challenge carries the reason, answered with an empty line.
+	if !strings.Contains(err.Error(), `(server: {"status":"401"`) {
+		t.Fatalf("error %q doesn't have the server error", err)
+	}
+	commands := server.commandLog()
+	if i := slices.IndexFunc(commands, func(c string) bool { return strings.HasPrefix(c, "AUTH XOAUTH2 ") }); i < 0 || i+1 >= len(commands) || commands[i+1] != "" {
+		t.Fatalf("challenge not answered with an empty line: %q", commands)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages, want none", n)
+	}
+}
+
+func TestXOAuth2TokenSourceError(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.tokens = map[string]string{"alerts@example.com": "ya29.token"}
+	})
+	refresh := errors.New("refresh token revoked")
+	hook := newAuthHook(t, server, "alerts@example.com", "", WithXOAuth2(func() (string, error) { return "", refresh }))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "no token")); !errors.Is(err, refresh) {
+		t.Fatalf("got %v, want the token source error", err)
+	}
+	if _, err := NewMailAuthHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", "", "",
+		WithoutConnectivityCheck(), WithXOAuth2(func() (string, error) { return "", nil })); !errors.Is(err, ErrEmptyCredentials) {
+		t.Fatalf("got %v, want ErrEmptyCredentials without a username", err)
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	hook := newAuthHook(t, server, "alerts", "secret", WithAuthMechanism(AuthLogin))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "login")); err != nil {
+		t.Fatal(err)
+	}
+	// The username and password answer the base64 encoded challenges.
+	commands := strings.Join(server.commandLog(), "|")
+	if !strings.Contains(commands, "AUTH LOGIN|YWxlcnRz|c2VjcmV0|") {
+		t.Fatalf("unexpected exchange %q", commands)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestLoginAuthInsecure(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	dialer := WithDialer(redirectDialer{addr: server.ln.Addr().String()})
+	newHook := func(opts ...MailOption) *MailAuthHook {
+		opts = append([]MailOption{dialer, WithAuthMechanism(AuthLogin)}, opts...)
+		hook, err := NewMailAuthHook("app", "mail.test", server.port(), "alerts@example.com", "ops@example.com", "alerts", "secret", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = hook.Close() })
+		return hook
+	}
+
+	err := newHook().Fire(testEntry(logrus.ErrorLevel, "plaintext"))
+	if err == nil || !strings.Contains(err.Error(), "WithAllowInsecureAuth") {
+		t.Fatalf("got %v, want the plaintext login refused", err)
+	}
+	for _, command := range server.commandLog() {
+		if command == "YWxlcnRz" || command == "c2VjcmV0" {
+			t.Fatalf("credentials sent in plaintext: %q", server.commandLog())
+		}
+	}
+
+	if err := newHook(WithAllowInsecureAuth()).Fire(testEntry(logrus.ErrorLevel, "allowed")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestLoginAuthUnexpectedChallenge(t *testing.T) {
+	auth := &loginAuth{username: "alerts", password: "secret"}
+	for challenge, want := range map[string]string{"Username:": "alerts", "password:": "secret"} {
+		response, err := auth.Next([]byte(challenge), true)
+		if err != nil || string(response) != want {
+			t.Fatalf("%q: got %q, %v, want %q", challenge, response, err, want)
+		}
+	}
+	if _, err := auth.Next([]byte("Token:"), true); err == nil {
+		t.Fatal("unexpected challenge answered")
+	}
+}
+
+func TestStrictTLSAuth(t *testing.T) {
+	insecure := WithTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	newHook := func(opts ...MailOption) error {
+		opts = append([]MailOption{WithoutConnectivityCheck(), insecure}, opts...)
+		_, err := NewMailAuthHook("app", "mail.test", 587, "alerts@example.com", "ops@example.com", "alerts", "secret", opts...)
+		return err
+	}
+
+	for _, mechanism := range []AuthMechanism{AuthPlain, AuthLogin, AuthAuto} {
+		if err := newHook(WithAuthMechanism(mechanism), WithStrictTLSAuth()); !errors.Is(err, ErrInsecureTLSAuth) {
+			t.Fatalf("%s: got %v, want ErrInsecureTLSAuth", mechanism, err)
+		}
+	}
+	if err := newHook(WithStrictTLSAuth()); !errors.Is(err, ErrInsecureTLSAuth) {
+		t.Fatalf("default mechanism: got %v, want ErrInsecureTLSAuth", err)
+	}
+	// The password isn't sent by CRAM-MD5, and without WithStrictTLSAuth it is only a warning.
+	if err := newHook(WithAuthMechanism(AuthCRAMMD5), WithStrictTLSAuth()); err != nil {
+		t.Fatalf("CRAM-MD5: %v", err)
+	}
+	var warning error
+	if err := newHook(WithOnError(func(_ *logrus.Entry, err error) { warning = err })); err != nil {
+		t.Fatalf("without WithStrictTLSAuth: %v", err)
+	}
+	if !errors.Is(warning, ErrInsecureTLSAuth) {
+		t.Fatalf("got warning %v, want ErrInsecureTLSAuth", warning)
+	}
+	if _, err := NewMailHook("app", "mail.test", 587, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), insecure, WithStrictTLSAuth()); err != nil {
+		t.Fatalf("hook without credentials: %v", err)
+	}
+}
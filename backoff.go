@@ -0,0 +1,67 @@
+package log_hooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithBackoffSuppression doubles the window of an error with every email sent for it,
+// starting with the message window of WithSuppressionWindows, up to maxWindow. The window
+// starts over once the error hasn't occurred for coolOff. Emails tell how long repeats
+// are suppressed.
+func WithBackoffSuppression(maxWindow time.Duration, coolOff time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if maxWindow <= 0 || coolOff <= 0 {
+			return fmt.Errorf("backoff window and cool-off must be positive, got %s and %s", maxWindow, coolOff)
+		}
+		o.backoffMax = maxWindow
+		o.backoffCoolOff = coolOff
+		return nil
+	}
+}
+
+// messageWindows returns the window an error of the level is checked against and the one
+// that applies after the next email for it.
+func (m *mailer) messageWindows(key string, level logrus.Level) (time.Duration, time.Duration) {
+	base := m.options.messageWindow
+	if window, ok := m.options.levelWindows[level]; ok {
+		base = window
+	}
+	if m.options.backoffMax == 0 {
+		return base, base
+	}
+	// Entries must outlive the cool-off to continue the streak.
+	m.store.observeWindow(max(m.options.backoffMax, m.options.backoffCoolOff))
+	sends := m.store.streak(key, m.options.backoffCoolOff)
+	return backoffWindow(base, sends-1, m.options.backoffMax), backoffWindow(base, sends, m.options.backoffMax)
+}
+
+// backoffWindow doubles base n times, up to limit.
+func backoffWindow(base time.Duration, n int, limit time.Duration) time.Duration {
+	window := base
+	for i := 0; i < n && window < limit; i++ {
+		window *= 2
+	}
+	return min(window, max(limit, base))
+}
+
+// streak returns the number of emails sent for the error since it last cooled off.
+func (es *DedupStore) streak(key string, coolOff time.Duration) int {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	return es.streakLocked(key, coolOff, es.now())
+}
+
+// streakLocked is streak for a caller holding errToTimeMu.
+func (es *DedupStore) streakLocked(key string, coolOff time.Duration, now time.Time) int {
+	last := es.errToTime[key]
+	if seen := es.errToSeen[key]; seen.After(last) {
+		last = seen
+	}
+	if now.Sub(last) > coolOff {
+		return 0
+	}
+	return es.errToSends[key]
+}
@@ -0,0 +1,72 @@
+package log_hooks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestBackoffSuppression(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))),
+		WithSuppressionWindows(0, time.Minute), WithBackoffSuppression(8*time.Minute, 30*time.Minute))
+
+	// The window doubles with every email up to 8 minutes, occurrences in between keep the
+	// streak going.
+	var elapsed time.Duration
+	for i, window := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 8 * time.Minute} {
+		if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "recurring")); n != 1 {
+			t.Fatalf("email %d after %s suppressed", i+1, elapsed)
+		}
+		clock.Advance(window - time.Second)
+		elapsed += window - time.Second
+		if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "recurring")); n != 0 {
+			t.Fatalf("email %d: repeat after %s sent, want the %s window", i+1, elapsed, window)
+		}
+		clock.Advance(time.Second)
+		elapsed += time.Second
+	}
+
+	// After the cool-off, the window starts over.
+	clock.Advance(31 * time.Minute)
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "recurring")); n != 1 {
+		t.Fatal("suppressed after the cool-off")
+	}
+	clock.Advance(time.Minute)
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "recurring")); n != 1 {
+		t.Fatal("window didn't start over after the cool-off")
+	}
+}
+
+func TestBackoffSuppressionNote(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))),
+		WithSuppressionWindows(0, time.Minute), WithBackoffSuppression(time.Hour, 2*time.Hour))
+
+	for _, want := range []string{"1m0s", "2m0s", "4m0s"} {
+		msg := fireMessage(t, hook, testEntry(logrus.ErrorLevel, "noted"))
+		if body := decodedBody(t, msg); !strings.Contains(body, "repeats are suppressed for "+want) {
+			t.Fatalf("body doesn't announce the %s window:\n%s", want, body)
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+func TestBackoffWindow(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want time.Duration
+	}{
+		{-1, time.Minute}, {0, time.Minute}, {1, 2 * time.Minute}, {3, 8 * time.Minute}, {4, 10 * time.Minute}, {100, 10 * time.Minute},
+	} {
+		if got := backoffWindow(time.Minute, tc.n, 10*time.Minute); got != tc.want {
+			t.Errorf("n %d: got %s, want %s", tc.n, got, tc.want)
+		}
+	}
+	// A limit below the base window keeps the base window.
+	if got := backoffWindow(time.Hour, 3, time.Minute); got != time.Hour {
+		t.Errorf("got %s, want 1h", got)
+	}
+}
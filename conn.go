@@ -0,0 +1,464 @@
+package log_hooks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithPersistentConnection keeps the SMTP connection open between sends instead of
+// dialing for every entry. Sends are serialized over the connection, which is reset
+// with RSET between messages and redialed when the server drops it.
+// Close the hook to QUIT the connection.
+func WithPersistentConnection() MailOption {
+	return WithConnectionPool(1, 0)
+}
+
+// WithConnectionPool keeps up to maxConns SMTP connections so concurrent entries are
+// sent in parallel. Connections idle for longer than idleTimeout are closed, the others
+// are kept alive with NOOP. A zero idleTimeout keeps idle connections forever.
+// Close the hook to QUIT the connections.
+func WithConnectionPool(maxConns int, idleTimeout time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if maxConns <= 0 {
+			return fmt.Errorf("max connections must be positive, got %d", maxConns)
+		}
+		if idleTimeout < 0 {
+			return fmt.Errorf("idle timeout must not be negative, got %s", idleTimeout)
+		}
+		o.poolSize = maxConns
+		o.poolIdleTimeout = idleTimeout
+		return nil
+	}
+}
+
+// DefaultSendTimeout bounds dialing and the SMTP conversation of a single send.
+const DefaultSendTimeout = 10 * time.Second
+
+// WithSendTimeout bounds dialing and the SMTP conversation of a single send.
+// Timeouts are reported as errors matching context.DeadlineExceeded.
+func WithSendTimeout(timeout time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if timeout <= 0 {
+			return fmt.Errorf("send timeout must be positive, got %s", timeout)
+		}
+		o.sendTimeout = timeout
+		return nil
+	}
+}
+
+// session is an SMTP client together with its connection, so deadlines can be set.
+type session struct {
+	*smtp.Client
+	conn net.Conn
+	// trace is nil unless WithSMTPTrace is set.
+	trace *smtpTrace
+}
+
+// dial connects to the endpoint and performs the configured TLS negotiation.
+// The conversation is bounded by the send timeout and the deadline of ctx,
+// cancelling ctx abandons it.
+func (o *mailOptions) dial(ctx context.Context, ep *endpoint) (s *session, err error) {
+	// With TLS 1.3 a rejected client certificate only shows up on the first read.
+	defer func() { err = clientAuthError(err) }()
+
+	addr := net.JoinHostPort(ep.host, strconv.Itoa(ep.port))
+	dialCtx, cancel := context.WithTimeout(ctx, o.sendTimeout)
+	defer cancel()
+
+	conn, err := o.dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if o.implicitTLS {
+		tlsConn := tls.Client(conn, ep.tlsConfig)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	setDeadline(ctx, conn, o.sendTimeout)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	trace := o.newTrace(addr)
+	client, err := smtp.NewClient(trace.wrapConn(conn), ep.host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, trace.attach(err)
+	}
+	if err := client.Hello(o.localName); err != nil {
+		_ = client.Close()
+		return nil, trace.attach(err)
+	}
+	if o.startTLS && !o.implicitTLS {
+		if err := startTLS(client, ep.tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, trace.attach(err)
+		}
+		trace.wrapClient(client)
+	}
+	return &session{Client: client, conn: conn, trace: trace}, nil
+}
+
+// connect dials the endpoint and authenticates if it has credentials.
+func (m *mailer) connect(ctx context.Context, ep *endpoint) (*session, error) {
+	s, err := m.options.dial(ctx, ep)
+	if err != nil {
+		return nil, err
+	}
+	if ep.username != "" {
+		stop := context.AfterFunc(ctx, func() { _ = s.conn.SetDeadline(time.Now()) })
+		err := m.authenticate(s, ep)
+		stop()
+		if err != nil {
+			_ = s.Close()
+			return nil, s.trace.attach(&authStepError{err: err})
+		}
+	}
+	return s, nil
+}
+
+// acquire returns a client ready for a new message, it must be given back with release.
+func (m *mailer) acquire(ctx context.Context, ep *endpoint) (*session, error) {
+	if ep.pool == nil {
+		s, err := m.connect(ctx, ep)
+		return s, timeoutError(err)
+	}
+
+	s, err := ep.pool.get(ctx, func() (*session, error) { return m.connect(ctx, ep) })
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+	setDeadline(ctx, s.conn, m.options.sendTimeout)
+	return s, nil
+}
+
+// setDeadline bounds the conversation by the timeout and the deadline of ctx.
+func setDeadline(ctx context.Context, conn net.Conn, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetDeadline(deadline)
+}
+
+// release finishes with a client returned by acquire. err is the result of the send.
+func (m *mailer) release(ep *endpoint, client *session, err error) {
+	if ep.pool != nil {
+		ep.pool.put(client, err)
+		return
+	}
+	quit(client, err)
+}
+
+// Ping checks the mail path without sending an email: it connects to the server like
+// a send does, with EHLO, TLS and authentication, and QUITs. With fallbacks it succeeds
+// when any of the servers works. The deadline of ctx shortens the send timeout.
+// A sender set by WithSender is pinged when it implements Pinger.
+func (m *mailer) Ping(ctx context.Context) error {
+	if m.options.dryRun != nil {
+		return nil
+	}
+	if pinger, ok := m.transport.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// pingEndpoints connects to the endpoints until one of them works.
+func (m *mailer) pingEndpoints(ctx context.Context) error {
+	var errs []error
+	for _, ep := range m.endpoints {
+		s, err := m.connect(ctx, ep)
+		if err == nil {
+			err = s.Quit()
+			if err != nil {
+				_ = s.Close()
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("smtp ping %s: %w", ep, timeoutError(err)))
+	}
+	return errors.Join(errs...)
+}
+
+// ErrClosed is returned by Fire and Close once the hook is closed.
+var ErrClosed = errors.New("mail hook is closed")
+
+// DefaultCloseTimeout bounds how long Close waits for queued emails.
+const DefaultCloseTimeout = 30 * time.Second
+
+// WithCloseTimeout bounds how long Close waits for the queued emails to be sent.
+func WithCloseTimeout(timeout time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if timeout <= 0 {
+			return fmt.Errorf("close timeout must be positive, got %s", timeout)
+		}
+		o.closeTimeout = timeout
+		return nil
+	}
+}
+
+// Close sends the pending digest and the queued emails and QUITs the open connections, if any.
+// It waits for the queue at most for the close timeout, later Fire calls return ErrClosed.
+// The dedup store is saved to its file, see WithDedupFile.
+func (m *mailer) Close() error {
+	if !m.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	var errs []error
+	if m.digest != nil {
+		errs = append(errs, m.digest.flush())
+	}
+	if m.summaries != nil {
+		errs = append(errs, m.summaries.close())
+	}
+	if m.queue != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), m.options.closeTimeout)
+		errs = append(errs, m.queue.close(ctx))
+		cancel()
+	}
+	if m.spool != nil {
+		m.spool.close()
+	}
+	for _, ep := range m.endpoints {
+		if ep.pool != nil {
+			errs = append(errs, ep.pool.close())
+		}
+	}
+	errs = append(errs, m.store.Save())
+	return errors.Join(errs...)
+}
+
+// quit ends the session politely unless it already failed.
+func quit(client *session, err error) {
+	if err != nil {
+		_ = client.Close()
+		return
+	}
+	if err := client.Quit(); err != nil {
+		_ = client.Close()
+	}
+}
+
+// timeoutError makes network timeouts match context.DeadlineExceeded.
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("smtp: %w: %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+// clientAuthError wraps TLS alerts about the client certificate with ErrTLSClientAuth.
+func clientAuthError(err error) error {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return err
+	}
+	// The alert type isn't exported, so match its description: bad, unsupported, revoked,
+	// expired or unknown certificate, unknown certificate authority, certificate required
+	// and access denied. TLS 1.2 servers missing a certificate send handshake_failure,
+	// which also means no common cipher, so it is left as is.
+	alert := opErr.Err.Error()
+	if strings.Contains(alert, "certificate") || strings.Contains(alert, "access denied") {
+		return fmt.Errorf("%w: %w", ErrTLSClientAuth, err)
+	}
+	return err
+}
+
+// isConnectionError reports whether err means the connection can't be reused.
+func isConnectionError(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code == 421
+	}
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+type pooledClient struct {
+	client   *session
+	lastUsed time.Time
+}
+
+// connPool keeps reusable SMTP connections.
+type connPool struct {
+	slots       chan struct{}
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []pooledClient
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newConnPool(size int, idleTimeout time.Duration) *connPool {
+	p := &connPool{
+		slots:       make(chan struct{}, size),
+		idleTimeout: idleTimeout,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go p.keepAlive()
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+// errPoolClosed is returned by get once the pool is closed.
+var errPoolClosed = errors.New("smtp connection pool is closed")
+
+// get borrows an idle connection or dials a new one, blocking while all are in use
+// until ctx is done or the pool is closed.
+func (p *connPool) get(ctx context.Context, connect func() (*session, error)) (*session, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.stop:
+		return nil, errPoolClosed
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.idleTimeout > 0 && time.Since(idle.lastUsed) > p.idleTimeout {
+			quit(idle.client, nil)
+			continue
+		}
+		_ = idle.client.conn.SetDeadline(time.Now().Add(p.idleTimeout + time.Second))
+		if err := idle.client.Reset(); err != nil {
+			_ = idle.client.Close()
+			continue
+		}
+		return idle.client, nil
+	}
+
+	client, err := connect()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return client, nil
+}
+
+// put gives a connection back, dropping it if err shows it's broken.
+func (p *connPool) put(client *session, err error) {
+	defer func() { <-p.slots }()
+
+	if err != nil && isConnectionError(err) {
+		_ = client.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		quit(client, nil)
+		return
+	}
+	_ = client.conn.SetDeadline(time.Time{})
+	p.idle = append(p.idle, pooledClient{client: client, lastUsed: time.Now()})
+}
+
+// keepAlive closes stale idle connections and pings the rest with NOOP.
+func (p *connPool) keepAlive() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		idle := p.idle
+		p.idle = nil
+		p.mu.Unlock()
+
+		var alive []pooledClient
+		for _, c := range idle {
+			if time.Since(c.lastUsed) > p.idleTimeout {
+				quit(c.client, nil)
+				continue
+			}
+			_ = c.client.conn.SetDeadline(time.Now().Add(p.idleTimeout))
+			if err := c.client.Noop(); err != nil {
+				_ = c.client.Close()
+				continue
+			}
+			alive = append(alive, c)
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			for _, c := range alive {
+				quit(c.client, nil)
+			}
+		} else {
+			for _, c := range alive {
+				_ = c.client.conn.SetDeadline(time.Time{})
+			}
+			p.idle = append(alive, p.idle...)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// close QUITs idle connections, the borrowed ones are closed when put back.
+func (p *connPool) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.done
+
+	var errs []error
+	for _, c := range idle {
+		if err := c.client.Quit(); err != nil {
+			_ = c.client.Close()
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
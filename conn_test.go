@@ -0,0 +1,90 @@
+package log_hooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestPersistentConnection(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithoutDedup(), WithPersistentConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "reused")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(server.received()); n != 3 {
+		t.Fatalf("got %d messages, want 3", n)
+	}
+	if n := server.connections.Load(); n != 1 {
+		t.Fatalf("got %d connections, want 1", n)
+	}
+	if commands := server.commandLog(); commands[len(commands)-1] != "QUIT" {
+		t.Fatalf("connection not closed with QUIT: %q", commands)
+	}
+}
+
+func TestPersistentConnectionReconnects(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		// The server drops the connection after every message.
+		s.dataReply = "421 closing"
+	})
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithoutDedup(), WithPersistentConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = hook.Close() }()
+
+	for i := 0; i < 2; i++ {
+		_ = hook.Fire(testEntry(logrus.ErrorLevel, "dropped"))
+	}
+	if n := server.connections.Load(); n != 2 {
+		t.Fatalf("got %d connections, want a new one after the 421", n)
+	}
+}
+
+func benchmarkFire(b *testing.B, opts ...MailOption) {
+	server := newTestServer(b, nil)
+	opts = append([]MailOption{WithoutConnectivityCheck(), WithoutDedup()}, opts...)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = hook.Close() }()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		entry := testEntry(logrus.ErrorLevel, "benchmark")
+		for pb.Next() {
+			if err := hook.Fire(entry); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(server.connections.Load())/float64(b.N), "conns/op")
+}
+
+func BenchmarkFireConnectionPerSend(b *testing.B) {
+	benchmarkFire(b)
+}
+
+func BenchmarkFirePersistentConnection(b *testing.B) {
+	benchmarkFire(b, WithPersistentConnection())
+}
+
+func BenchmarkFireConnectionPool(b *testing.B) {
+	benchmarkFire(b, WithConnectionPool(4, time.Minute))
+}
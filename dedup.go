@@ -0,0 +1,394 @@
+package log_hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// KeyFunc returns the deduplication key of an entry, entries with the same key are
+// suppressed as duplicates. An empty key sends the entry without deduplication and
+// rate limiting.
+type KeyFunc func(entry *logrus.Entry) string
+
+// WithDedupKey deduplicates with fn instead of the message, see MessageWithFields and FirstLine.
+func WithDedupKey(fn KeyFunc) MailOption {
+	return func(o *mailOptions) error {
+		if fn == nil {
+			return errors.New("dedup key func must not be nil")
+		}
+		o.dedupKey = fn
+		return nil
+	}
+}
+
+// WithoutDedup sends every entry, without deduplication and rate limiting, for jobs that
+// log a handful of errors that must all arrive.
+func WithoutDedup() MailOption {
+	return func(o *mailOptions) error {
+		o.noDedup = true
+		return nil
+	}
+}
+
+// WithDedupFields deduplicates on the message together with the values of the fields,
+// like WithDedupKey(MessageWithFields(keys...)).
+func WithDedupFields(keys ...string) MailOption {
+	return WithDedupKey(MessageWithFields(keys...))
+}
+
+// WithDedupField deduplicates on the value of the field, like a stable "error_code",
+// for the entries that have it, and on the message for the others.
+// It is WithDedupKey(FieldOrMessage(field)).
+func WithDedupField(field string) MailOption {
+	return WithDedupKey(FieldOrMessage(field))
+}
+
+// FieldOrMessage keys on the string value of the field when the entry has it,
+// otherwise on the message.
+func FieldOrMessage(field string) KeyFunc {
+	return func(entry *logrus.Entry) string {
+		value, ok := entry.Data[field]
+		if !ok {
+			return entry.Message
+		}
+		// Prefixed, so a value never collides with a message.
+		return field + "=" + fmt.Sprint(value)
+	}
+}
+
+// MessageOnly is the default key, the message of the entry.
+func MessageOnly(entry *logrus.Entry) string {
+	return entry.Message
+}
+
+// FirstLine keys on the first line of the message, so errors with details or a trace
+// on the following lines are grouped.
+func FirstLine(entry *logrus.Entry) string {
+	line, _, _ := strings.Cut(entry.Message, "\n")
+	return strings.TrimRight(line, "\r")
+}
+
+// KeyPrefix keys on the first n characters of the message, counting runes so a multibyte
+// character is never split. With n <= 0 it keys on the whole message.
+func KeyPrefix(n int) KeyFunc {
+	return func(entry *logrus.Entry) string {
+		if n <= 0 {
+			return entry.Message
+		}
+		i := 0
+		for offset := range entry.Message {
+			if i == n {
+				return entry.Message[:offset]
+			}
+			i++
+		}
+		return entry.Message
+	}
+}
+
+// KeyBeforeDelimiter keys on the message up to the first delim, so "db query failed: <details>"
+// is keyed "db query failed". Messages without delim, or starting with it, are keyed whole,
+// an empty key would bypass the deduplication.
+func KeyBeforeDelimiter(delim string) KeyFunc {
+	return func(entry *logrus.Entry) string {
+		if before, _, _ := strings.Cut(entry.Message, delim); before != "" && delim != "" {
+			return before
+		}
+		return entry.Message
+	}
+}
+
+// MessageWithFields keys on the message together with the values of the fields, so
+// "request failed" with another endpoint or status is a different error. An absent
+// field differs from an empty one.
+func MessageWithFields(keys ...string) KeyFunc {
+	keys = append([]string(nil), keys...)
+	return func(entry *logrus.Entry) string {
+		return fieldsKey(entry, keys)
+	}
+}
+
+// fieldsKey hashes the message with the values of the fields.
+func fieldsKey(entry *logrus.Entry, keys []string) string {
+	hash := sha256.New()
+	writeKeyPart(hash, entry.Message)
+	for _, key := range keys {
+		writeKeyPart(hash, key)
+		value, ok := entry.Data[key]
+		if !ok {
+			// Distinct from any encoded value, which is never empty.
+			writeKeyPart(hash, "")
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprintf("%+v", value))
+		}
+		writeKeyPart(hash, "="+string(encoded))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeKeyPart writes a length-prefixed part, so parts can't run into each other.
+func writeKeyPart(w io.Writer, part string) {
+	_, _ = fmt.Fprintf(w, "%d:%s", len(part), part)
+}
+
+// minSweepSize is the number of tracked keys below which the store is only swept on time.
+const minSweepSize = 1024
+
+// observeWindow remembers the largest window the store is used with, entries older than it
+// can't suppress anything.
+func (es *DedupStore) observeWindow(window time.Duration) {
+	for {
+		current := es.maxWindow.Load()
+		if int64(window) <= current || es.maxWindow.CompareAndSwap(current, int64(window)) {
+			return
+		}
+	}
+}
+
+// sweep forgets the errors and recipient sets that had no activity for the largest window,
+// together with their thread and the count of suppressed entries. It runs once a window,
+// at least a minute, or when the tracked keys doubled since the last sweep, so the store
+// stays bounded by what the window holds. The caller holds errToTimeMu.
+func (es *DedupStore) sweep(now time.Time) {
+	window := time.Duration(es.maxWindow.Load())
+	size := len(es.errToTime) + len(es.errToSeen) + len(es.recipientsToTime)
+	if now.Sub(es.lastSweep) < max(window, time.Minute) && size < 2*max(es.sweptSize, minSweepSize) {
+		return
+	}
+
+	cutoff := now.Add(-window)
+	expire := func(key string) {
+		if es.errToTime[key].Before(cutoff) && es.errToSeen[key].Before(cutoff) {
+			es.drop(key)
+			es.expired.Add(1)
+		}
+	}
+	for key := range es.errToTime {
+		expire(key)
+	}
+	for key := range es.errToSeen {
+		expire(key)
+	}
+	for key := range es.errToThread {
+		expire(key)
+	}
+	for key := range es.errToSuppressed {
+		expire(key)
+	}
+	for key, sent := range es.recipientsToTime {
+		if sent.Before(cutoff) {
+			delete(es.recipientsToTime, key)
+		}
+	}
+	es.lastSweep = now
+	es.sweptSize = len(es.errToTime) + len(es.errToSeen) + len(es.recipientsToTime)
+}
+
+// DedupStoreOption configures optional behaviour of DedupStore.
+type DedupStoreOption func(*DedupStore)
+
+// WithMaxTrackedErrors bounds the errors the store remembers, evicting the least recently
+// seen ones beyond n, so a burst of distinct messages can't exhaust memory. An evicted error
+// is sent again the next time it occurs. The rate limits of the recipient sets are kept.
+// Evictions are counted in Stats.Evicted.
+func WithMaxTrackedErrors(n int) DedupStoreOption {
+	return func(es *DedupStore) {
+		es.maxTracked = n
+	}
+}
+
+// WindowMode is where the message window of an error starts, see WithWindowMode.
+type WindowMode int
+
+const (
+	// SinceLastSent suppresses an error for the window after its last email, the default.
+	SinceLastSent WindowMode = iota
+	// SinceLastSeen suppresses an error until it was quiet for the window, so an error that
+	// keeps occurring is only sent again after a pause.
+	SinceLastSeen
+)
+
+// WithWindowMode sets where the message window of an error starts, the default is SinceLastSent.
+func WithWindowMode(mode WindowMode) DedupStoreOption {
+	return func(es *DedupStore) {
+		es.windowMode = mode
+	}
+}
+
+// windowStart is the time the message window of a known error starts from.
+// The caller holds errToTimeMu.
+func (es *DedupStore) windowStart(key string) (time.Time, bool) {
+	start, ok := es.errToTime[key]
+	if window, counted := es.errToWindow[key]; counted {
+		start, ok = window.start, true
+	}
+	if es.windowMode == SinceLastSeen {
+		if seen, seenOK := es.errToSeen[key]; seenOK && seen.After(start) {
+			return seen, true
+		}
+	}
+	return start, ok
+}
+
+// WithClock makes the store read the time from now instead of time.Now, so tests can move
+// it across the suppression windows without sleeping.
+func WithClock(now func() time.Time) DedupStoreOption {
+	return func(es *DedupStore) {
+		if now != nil {
+			es.now = now
+		}
+	}
+}
+
+// touch marks the error as the most recently seen one and evicts the least recently seen
+// ones over the limit. The caller holds errToTimeMu.
+func (es *DedupStore) touch(key string, now time.Time) {
+	if _, ok := es.errToFirst[key]; !ok {
+		es.errToFirst[key] = now
+	}
+	if element, ok := es.recentKeys[key]; ok {
+		es.recent.MoveToFront(element)
+	} else {
+		es.recentKeys[key] = es.recent.PushFront(key)
+	}
+	for es.maxTracked > 0 && es.recent.Len() > es.maxTracked {
+		es.drop(es.recent.Back().Value.(string))
+		es.evicted.Add(1)
+	}
+}
+
+// WithOnEvict calls fn with the key and the last occurrence of every error the store evicts
+// for WithMaxTrackedErrors or forgets after its window, e.g. to log at debug level that
+// high-cardinality messages churn the store. fn is called after the store is unlocked, by the
+// goroutine that logged, so it should log through a logger without the mail hook.
+func WithOnEvict(fn func(key string, lastSeen time.Time)) DedupStoreOption {
+	return func(es *DedupStore) {
+		es.onEvict = fn
+	}
+}
+
+// droppedKey is an error the store forgot, see WithOnEvict.
+type droppedKey struct {
+	key      string
+	lastSeen time.Time
+}
+
+// drop forgets an error the store didn't keep, noting it for WithOnEvict. The caller holds
+// errToTimeMu.
+func (es *DedupStore) drop(key string) {
+	if es.onEvict != nil {
+		lastSeen := es.errToTime[key]
+		if seen := es.errToSeen[key]; seen.After(lastSeen) {
+			lastSeen = seen
+		}
+		es.droppedMu.Lock()
+		es.dropped = append(es.dropped, droppedKey{key: key, lastSeen: lastSeen})
+		es.droppedMu.Unlock()
+	}
+	es.forget(key)
+}
+
+// notifyDropped passes the dropped errors to the callback of WithOnEvict. It is deferred
+// before errToTimeMu is locked, so it runs once the lock is released.
+func (es *DedupStore) notifyDropped() {
+	if es.onEvict == nil {
+		return
+	}
+	es.droppedMu.Lock()
+	dropped := es.dropped
+	es.dropped = nil
+	es.droppedMu.Unlock()
+	for _, d := range dropped {
+		es.onEvict(d.key, d.lastSeen)
+	}
+}
+
+// Clear forgets all errors and rate limits, so the next occurrence of every error is sent.
+func (es *DedupStore) Clear() {
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	es.reset()
+}
+
+// Forget drops a single error, by default keyed by its message, see WithDedupKey,
+// so its next occurrence is sent unless the recipients are rate limited. Errors routed to
+// other recipients than the hook ones are keyed by the sorted, comma separated recipients,
+// a NUL byte and the key.
+func (es *DedupStore) Forget(key string) {
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	es.forget(key)
+}
+
+// ResetSuppression clears the dedup store of the hook, see DedupStore.Clear. A store shared
+// with WithDedupStore is cleared for all its hooks.
+func (m *mailer) ResetSuppression() {
+	m.store.Clear()
+}
+
+// SuppressionState describes an error remembered by a DedupStore, see Snapshot.
+type SuppressionState struct {
+	Key string `json:"key"`
+	// FirstSeen is when the error first occurred since the store remembers it.
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// LastSent is zero when no email was sent for the error yet.
+	LastSent time.Time `json:"last_sent"`
+	// SuppressedCount is the number of occurrences since the last email.
+	SuppressedCount int `json:"suppressed_count"`
+}
+
+// Snapshot returns the errors the store remembers, the most recently seen first.
+func (es *DedupStore) Snapshot() []SuppressionState {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	states := make([]SuppressionState, 0, es.recent.Len())
+	for element := es.recent.Front(); element != nil; element = element.Next() {
+		key := element.Value.(string)
+		state := SuppressionState{
+			Key:             key,
+			FirstSeen:       es.errToFirst[key],
+			LastSeen:        es.errToSeen[key],
+			LastSent:        es.errToTime[key],
+			SuppressedCount: es.errToSuppressed[key],
+		}
+		if state.LastSent.After(state.LastSeen) {
+			state.LastSeen = state.LastSent
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// SuppressionSnapshot returns the errors remembered by the dedup store of the hook, see
+// DedupStore.Snapshot.
+func (m *mailer) SuppressionSnapshot() []SuppressionState {
+	return m.store.Snapshot()
+}
+
+// forget removes everything the store knows about the error. The caller holds errToTimeMu.
+func (es *DedupStore) forget(key string) {
+	delete(es.errToTime, key)
+	delete(es.errToSeen, key)
+	delete(es.errToThread, key)
+	delete(es.errToSuppressed, key)
+	delete(es.errToSends, key)
+	delete(es.errToWindow, key)
+	delete(es.errToClaim, key)
+	delete(es.errToFirst, key)
+	if element, ok := es.recentKeys[key]; ok {
+		es.recent.Remove(element)
+		delete(es.recentKeys, key)
+	}
+}
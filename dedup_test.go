@@ -0,0 +1,364 @@
+package log_hooks
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// countSent fires the entries and returns how many emails were sent for them.
+func countSent(t *testing.T, hook *MailHook, entries ...*logrus.Entry) int {
+	t.Helper()
+	before := len(hook.DryRunMessages())
+	for _, entry := range entries {
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return len(hook.DryRunMessages()) - before
+}
+
+func entryWith(message string, fields logrus.Fields) *logrus.Entry {
+	entry := testEntry(logrus.ErrorLevel, message)
+	for key, value := range fields {
+		entry.Data[key] = value
+	}
+	return entry
+}
+
+func TestKeyFuncs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		key  KeyFunc
+		same [2]*logrus.Entry
+		diff [2]*logrus.Entry
+	}{
+		"MessageOnly": {
+			key:  MessageOnly,
+			same: [2]*logrus.Entry{entryWith("timeout", logrus.Fields{"id": 1}), entryWith("timeout", logrus.Fields{"id": 2})},
+			diff: [2]*logrus.Entry{entryWith("timeout", nil), entryWith("timeout!", nil)},
+		},
+		"FirstLine": {
+			key:  FirstLine,
+			same: [2]*logrus.Entry{entryWith("panic: boom\r\ngoroutine 1", nil), entryWith("panic: boom\ngoroutine 7", nil)},
+			diff: [2]*logrus.Entry{entryWith("panic: boom\n", nil), entryWith("panic: bang\n", nil)},
+		},
+		"MessageWithFields": {
+			key:  MessageWithFields("endpoint", "status"),
+			same: [2]*logrus.Entry{entryWith("failed", logrus.Fields{"endpoint": "/a", "status": 500, "id": 1}), entryWith("failed", logrus.Fields{"endpoint": "/a", "status": 500, "id": 2})},
+			diff: [2]*logrus.Entry{entryWith("failed", logrus.Fields{"endpoint": "/a", "status": 500}), entryWith("failed", logrus.Fields{"endpoint": "/a", "status": 502})},
+		},
+		"MessageWithFields absent": {
+			key:  MessageWithFields("endpoint"),
+			same: [2]*logrus.Entry{entryWith("failed", nil), entryWith("failed", nil)},
+			diff: [2]*logrus.Entry{entryWith("failed", nil), entryWith("failed", logrus.Fields{"endpoint": ""})},
+		},
+		"FieldOrMessage": {
+			key:  FieldOrMessage("error_code"),
+			same: [2]*logrus.Entry{entryWith("disk full on /a", logrus.Fields{"error_code": "ENOSPC"}), entryWith("disk full on /b", logrus.Fields{"error_code": "ENOSPC"})},
+			diff: [2]*logrus.Entry{entryWith("ENOSPC", nil), entryWith("other", logrus.Fields{"error_code": "ENOSPC"})},
+		},
+		"KeyPrefix": {
+			key:  KeyPrefix(7),
+			same: [2]*logrus.Entry{entryWith("Ошибка: 1", nil), entryWith("Ошибка: 2", nil)},
+			diff: [2]*logrus.Entry{entryWith("Ошибка", nil), entryWith("Ошибк", nil)},
+		},
+		"KeyBeforeDelimiter": {
+			key:  KeyBeforeDelimiter(": "),
+			same: [2]*logrus.Entry{entryWith("db query failed: timeout", nil), entryWith("db query failed: reset", nil)},
+			diff: [2]*logrus.Entry{entryWith(": a", nil), entryWith(": b", nil)},
+		},
+	} {
+		if a, b := tc.key(tc.same[0]), tc.key(tc.same[1]); a != b || a == "" {
+			t.Errorf("%s: got keys %q and %q, want the same", name, a, b)
+		}
+		if a, b := tc.key(tc.diff[0]), tc.key(tc.diff[1]); a == b {
+			t.Errorf("%s: got key %q for both, want different ones", name, a)
+		}
+	}
+}
+
+func TestDedupKeyGrouping(t *testing.T) {
+	ids := regexp.MustCompile(`id=\w+`)
+	hook := newDryRunHook(t, WithSuppressionWindows(0, time.Hour), WithDedupKey(func(entry *logrus.Entry) string {
+		if entry.Data["type"] == "audit" {
+			return ""
+		}
+		if errType, ok := entry.Data["type"].(string); ok {
+			return errType
+		}
+		return ids.ReplaceAllString(entry.Message, "id=")
+	}))
+
+	if n := countSent(t, hook, entryWith("user id=1 not found", nil), entryWith("user id=2 not found", nil)); n != 1 {
+		t.Fatalf("volatile IDs: got %d emails, want 1", n)
+	}
+	if n := countSent(t, hook, entryWith("conn reset", logrus.Fields{"type": "network"}), entryWith("dns failure", logrus.Fields{"type": "network"})); n != 1 {
+		t.Fatalf("error type: got %d emails, want 1", n)
+	}
+	// An empty key bypasses the deduplication.
+	if n := countSent(t, hook, entryWith("login", logrus.Fields{"type": "audit"}), entryWith("login", logrus.Fields{"type": "audit"})); n != 2 {
+		t.Fatalf("empty key: got %d emails, want 2", n)
+	}
+}
+
+func TestWithDedupKeyNil(t *testing.T) {
+	_, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", WithoutConnectivityCheck(), WithDedupKey(nil))
+	if err == nil {
+		t.Fatal("nil key func accepted")
+	}
+}
+
+// testClock is a clock for WithClock that only moves when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// trySend acquires the key like a hook sending to recipients does, and marks the email
+// as delivered when it may be sent.
+func trySend(es *DedupStore, recipients string, key string, recipientWindow time.Duration, messageWindow time.Duration) bool {
+	res, _, ok := es.tryAcquire(recipients, key, bucket{every: recipientWindow, burst: 1}, messageWindow, 1, 0)
+	if ok {
+		es.markSent(res, "<"+key+">", 0)
+	}
+	return ok
+}
+
+// tracked returns the number of entries of the largest per-error map of the store.
+func tracked(es *DedupStore) int {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	return max(len(es.errToTime), len(es.errToSeen), len(es.errToThread), len(es.errToSuppressed),
+		len(es.errToSends), len(es.errToWindow), len(es.errToFirst), len(es.recentKeys))
+}
+
+func TestSweepBoundsStore(t *testing.T) {
+	clock := newTestClock()
+	store := NewDedupStore(WithClock(clock.Now))
+
+	// A minute holds 6000 of the errors, logged every 10ms.
+	peak := 0
+	for i := 0; i < 100000; i++ {
+		key := fmt.Sprintf("user %d not found", i)
+		if !trySend(store, "ops@example.com", key, 0, time.Minute) {
+			t.Fatalf("%q suppressed", key)
+		}
+		store.suppress(key)
+		clock.Advance(10 * time.Millisecond)
+		peak = max(peak, tracked(store))
+	}
+	if peak > 3*6000 {
+		t.Fatalf("store grew to %d errors", peak)
+	}
+	if expired := store.Stats().Expired; expired < 100000-3*6000 {
+		t.Fatalf("got %d errors expired", expired)
+	}
+}
+
+func TestSweepKeepsLiveErrors(t *testing.T) {
+	clock := newTestClock()
+	store := NewDedupStore(WithClock(clock.Now))
+	if !trySend(store, "ops@example.com", "live", 0, 10*time.Minute) {
+		t.Fatal("first send suppressed")
+	}
+
+	// A burst of distinct errors and a sweep after a minute leave the live error alone.
+	clock.Advance(time.Minute)
+	for i := 0; i < 5000; i++ {
+		store.suppress(fmt.Sprint(i))
+	}
+	if trySend(store, "ops@example.com", "live", 0, 10*time.Minute) {
+		t.Fatal("live error swept")
+	}
+	if thread := store.thread("live"); thread != "<live>" {
+		t.Fatalf("got thread %q", thread)
+	}
+
+	// Past the window it is forgotten with the burst on the next sweep.
+	clock.Advance(11 * time.Minute)
+	store.suppress("other")
+	if n := len(store.Snapshot()); n != 1 {
+		t.Fatalf("got %d errors after the window, want 1", n)
+	}
+}
+
+func TestMaxTrackedErrors(t *testing.T) {
+	store := NewDedupStore(WithMaxTrackedErrors(3))
+	for _, key := range []string{"a", "b", "c"} {
+		if !trySend(store, key+"@example.com", key, 0, time.Hour) {
+			t.Fatalf("%q suppressed", key)
+		}
+	}
+	// a is seen again, so b is the least recently seen one.
+	store.suppress("a")
+	if !trySend(store, "d@example.com", "d", 0, time.Hour) {
+		t.Fatal("d suppressed")
+	}
+
+	if thread := store.thread("b"); thread != "" {
+		t.Fatalf("b not evicted, thread %q", thread)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if thread := store.thread(key); thread != "<"+key+">" {
+			t.Fatalf("%q evicted", key)
+		}
+	}
+	if evicted := store.Stats().Evicted; evicted != 1 {
+		t.Fatalf("got %d evicted, want 1", evicted)
+	}
+	// The evicted error is sent again, the rate limits of the recipients are kept.
+	if !trySend(store, "b2@example.com", "b", 0, time.Hour) {
+		t.Fatal("evicted error suppressed")
+	}
+	if trySend(store, "a@example.com", "new", time.Hour, time.Hour) {
+		t.Fatal("rate limit of a recipient evicted")
+	}
+}
+
+func TestMaxTrackedErrorsConcurrent(t *testing.T) {
+	store := NewDedupStore(WithMaxTrackedErrors(16))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("error %d-%d", g, i%64)
+				trySend(store, "ops@example.com", key, 0, time.Minute)
+				store.suppress(key)
+				_, _ = store.suppressed(key)
+				_ = store.thread(key)
+				_ = store.Stats()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := tracked(store); n > 16 {
+		t.Fatalf("got %d tracked errors, want at most 16", n)
+	}
+	if store.recent.Len() != len(store.recentKeys) {
+		t.Fatalf("recency list has %d entries, index %d", store.recent.Len(), len(store.recentKeys))
+	}
+	if store.Stats().Evicted == 0 {
+		t.Fatal("nothing evicted")
+	}
+}
+
+func TestSuppressionWindowsWithClock(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))))
+	send := func(message string) bool {
+		t.Helper()
+		return countSent(t, hook, testEntry(logrus.ErrorLevel, message)) == 1
+	}
+
+	if !send("disk full") {
+		t.Fatal("first email suppressed")
+	}
+	// The recipients get one email a minute.
+	clock.Advance(time.Minute - time.Nanosecond)
+	if send("queue stuck") {
+		t.Fatal("recipient window ignored")
+	}
+	clock.Advance(time.Nanosecond)
+	if !send("queue stuck") {
+		t.Fatal("suppressed after the recipient window")
+	}
+
+	// The same error is sent once every 10 minutes.
+	clock.Advance(9*time.Minute - time.Nanosecond)
+	if send("disk full") {
+		t.Fatal("message window ignored")
+	}
+	clock.Advance(time.Nanosecond)
+	if !send("disk full") {
+		t.Fatal("suppressed after the message window")
+	}
+}
+
+func TestSuppressionWindowsOption(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))),
+		WithSuppressionWindows(10*time.Second, time.Minute), WithLevelSuppressionWindow(logrus.WarnLevel, 5*time.Minute))
+
+	for _, step := range []struct {
+		advance time.Duration
+		level   logrus.Level
+		message string
+		sent    bool
+	}{
+		{0, logrus.ErrorLevel, "error", true},
+		{10 * time.Second, logrus.WarnLevel, "warning", true},
+		{50 * time.Second, logrus.ErrorLevel, "error", true},
+		{10 * time.Second, logrus.WarnLevel, "warning", false},
+		{4 * time.Minute, logrus.WarnLevel, "warning", true},
+	} {
+		clock.Advance(step.advance)
+		if sent := countSent(t, hook, testEntry(step.level, step.message)) == 1; sent != step.sent {
+			t.Fatalf("%s %q after %s: sent %v, want %v", step.level, step.message, step.advance, sent, step.sent)
+		}
+	}
+}
+
+func TestWindowModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode WindowMode
+		// sent tells which of the occurrences at 0, 5, 9, 10, 19 and 30 minutes are emailed.
+		sent []bool
+	}{
+		{SinceLastSent, []bool{true, false, false, true, false, true}},
+		{SinceLastSeen, []bool{true, false, false, false, false, true}},
+	} {
+		clock := newTestClock()
+		start := clock.Now()
+		hook := newDryRunHook(t, WithSuppressionWindows(0, 10*time.Minute),
+			WithDedupStore(NewDedupStore(WithClock(clock.Now), WithWindowMode(tc.mode))))
+
+		for i, at := range []time.Duration{0, 5 * time.Minute, 9 * time.Minute, 10 * time.Minute, 19 * time.Minute, 30 * time.Minute} {
+			clock.Advance(start.Add(at).Sub(clock.Now()))
+			if sent := countSent(t, hook, testEntry(logrus.ErrorLevel, "flapping")) == 1; sent != tc.sent[i] {
+				t.Fatalf("mode %d at %s: sent %v, want %v", tc.mode, at, sent, tc.sent[i])
+			}
+		}
+	}
+}
+
+func TestSinceLastSeenQuietPeriod(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithSuppressionWindows(0, 10*time.Minute),
+		WithDedupStore(NewDedupStore(WithClock(clock.Now), WithWindowMode(SinceLastSeen))))
+
+	countSent(t, hook, testEntry(logrus.ErrorLevel, "flapping"))
+	// An error occurring every 5 minutes is never quiet for the window.
+	for i := 0; i < 10; i++ {
+		clock.Advance(5 * time.Minute)
+		if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "flapping")); n != 0 {
+			t.Fatalf("sent after %d minutes without a quiet window", 5*(i+1))
+		}
+	}
+	clock.Advance(10 * time.Minute)
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "flapping")); n != 1 {
+		t.Fatal("suppressed after a quiet window")
+	}
+}
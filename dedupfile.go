@@ -0,0 +1,218 @@
+package log_hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WithDedupFile keeps the state of the store in path across restarts, so a deploy doesn't
+// send the alerts suppressed minutes before again. The file is read when the store is created,
+// leaving out what is past the suppression window, and written by Save and when a hook using
+// the store is closed. A missing or unreadable file starts an empty store.
+func WithDedupFile(path string) DedupStoreOption {
+	return func(es *DedupStore) {
+		es.path = path
+	}
+}
+
+// dedupFormatVersion is the version of dedupFile. Files without a version are version 1.
+const dedupFormatVersion = 1
+
+// dedupFile is the content of the file of WithDedupFile and the JSON of the store.
+type dedupFile struct {
+	Version int `json:"version"`
+	// Window is the largest suppression window the store was used with.
+	Window     time.Duration        `json:"window"`
+	Errors     map[string]dedupErr  `json:"errors"`
+	Recipients map[string]time.Time `json:"recipients"`
+	// Recent are the keys of Errors, the most recently seen first, see WithMaxTrackedErrors.
+	Recent []string `json:"recent,omitempty"`
+}
+
+type dedupErr struct {
+	Sent       time.Time `json:"sent,omitempty"`
+	Seen       time.Time `json:"seen,omitempty"`
+	Thread     string    `json:"thread,omitempty"`
+	Suppressed int       `json:"suppressed,omitempty"`
+	Sends      int       `json:"sends,omitempty"`
+	First      time.Time `json:"first,omitempty"`
+	// Window and WindowSends are the message window of the error, see WithMaxPerWindow.
+	// Errors without them used up the window starting at Sent.
+	Window      time.Time `json:"window,omitempty"`
+	WindowSends int       `json:"window_sends,omitempty"`
+}
+
+func (es *DedupStore) load() {
+	content, err := os.ReadFile(es.path)
+	if err != nil {
+		return
+	}
+	var file dedupFile
+	if json.Unmarshal(content, &file) != nil || file.Version > dedupFormatVersion {
+		return
+	}
+
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	es.restore(file, es.now().Add(-file.Window))
+}
+
+// restore adds the state of file, leaving out what had no activity since cutoff.
+// The caller holds errToTimeMu.
+func (es *DedupStore) restore(file dedupFile, cutoff time.Time) {
+	es.observeWindow(file.Window)
+	keys := make([]string, 0, len(file.Errors))
+	for key, state := range file.Errors {
+		if state.Sent.Before(cutoff) && state.Seen.Before(cutoff) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	// Touched from the least recently seen, so the order of WithMaxTrackedErrors survives.
+	// Files without Recent are ordered by the last activity.
+	rank := make(map[string]int, len(file.Recent))
+	for i, key := range file.Recent {
+		rank[key] = len(file.Recent) - i
+	}
+	last := func(state dedupErr) time.Time {
+		if state.Seen.After(state.Sent) {
+			return state.Seen
+		}
+		return state.Sent
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if rank[keys[i]] != rank[keys[j]] {
+			return rank[keys[i]] < rank[keys[j]]
+		}
+		return last(file.Errors[keys[i]]).Before(last(file.Errors[keys[j]]))
+	})
+	for _, key := range keys {
+		state := file.Errors[key]
+		if !state.Sent.IsZero() {
+			es.errToTime[key] = state.Sent
+		}
+		if !state.Seen.IsZero() {
+			es.errToSeen[key] = state.Seen
+		}
+		if state.Thread != "" {
+			es.errToThread[key] = state.Thread
+		}
+		if state.Suppressed > 0 {
+			es.errToSuppressed[key] = state.Suppressed
+		}
+		if state.Sends > 0 {
+			es.errToSends[key] = state.Sends
+		}
+		if !state.First.IsZero() {
+			es.errToFirst[key] = state.First
+		}
+		if state.WindowSends > 0 {
+			es.errToWindow[key] = windowCount{start: state.Window, sends: state.WindowSends}
+		}
+		first := state.Sent
+		if first.IsZero() || state.Seen.Before(first) && !state.Seen.IsZero() {
+			first = state.Seen
+		}
+		es.touch(key, first)
+	}
+	for key, sent := range file.Recipients {
+		if !sent.Before(cutoff) {
+			es.recipientsToTime[key] = sent
+		}
+	}
+}
+
+// export returns the state of the store.
+func (es *DedupStore) export() dedupFile {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	file := dedupFile{
+		Version:    dedupFormatVersion,
+		Window:     time.Duration(es.maxWindow.Load()),
+		Errors:     make(map[string]dedupErr, len(es.recentKeys)),
+		Recipients: make(map[string]time.Time, len(es.recipientsToTime)),
+		Recent:     make([]string, 0, es.recent.Len()),
+	}
+	for element := es.recent.Front(); element != nil; element = element.Next() {
+		key := element.Value.(string)
+		file.Recent = append(file.Recent, key)
+		file.Errors[key] = dedupErr{
+			Sent:        es.errToTime[key],
+			Seen:        es.errToSeen[key],
+			Thread:      es.errToThread[key],
+			Suppressed:  es.errToSuppressed[key],
+			Sends:       es.errToSends[key],
+			First:       es.errToFirst[key],
+			Window:      es.errToWindow[key].start,
+			WindowSends: es.errToWindow[key].sends,
+		}
+	}
+	for key, sent := range es.recipientsToTime {
+		file.Recipients[key] = sent
+	}
+	return file
+}
+
+// MarshalJSON dumps the suppression state: the errors with their times, threads and
+// counters, and the rate limits of the recipient sets, in the format of WithDedupFile.
+func (es *DedupStore) MarshalJSON() ([]byte, error) {
+	return json.Marshal(es.export())
+}
+
+// UnmarshalJSON replaces the state of the store with a dump of MarshalJSON, keeping
+// all of it, also into a zero DedupStore. Dumps of a newer format are rejected.
+func (es *DedupStore) UnmarshalJSON(data []byte) error {
+	var file dedupFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Version > dedupFormatVersion {
+		return fmt.Errorf("unsupported dedup store version %d", file.Version)
+	}
+
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	if es.now == nil {
+		es.now = time.Now
+	}
+	es.reset()
+	es.restore(file, time.Time{})
+	return nil
+}
+
+// Save writes the store to the file set with WithDedupFile, if any.
+func (es *DedupStore) Save() error {
+	if es.path == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(es.export())
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash never leaves a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(es.path), ".dedup-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), es.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
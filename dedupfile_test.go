@@ -0,0 +1,75 @@
+package log_hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDedupStoreJSONRoundTrip(t *testing.T) {
+	clock := newTestClock()
+	store := NewDedupStore(WithClock(clock.Now), WithMaxTrackedErrors(10))
+	trySend(store, "ops@example.com", "disk full", time.Minute, 10*time.Minute)
+	clock.Advance(time.Minute)
+	trySend(store, "ops@example.com", "queue stuck", time.Minute, 10*time.Minute)
+	store.suppress("disk full")
+	store.suppress("disk full")
+	clock.Advance(time.Second)
+
+	dump, err := json.Marshal(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored DedupStore
+	if err := json.Unmarshal(dump, &restored); err != nil {
+		t.Fatal(err)
+	}
+	again, err := json.Marshal(&restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dump, again) {
+		t.Fatalf("round trip changed the dump:\n%s\n%s", dump, again)
+	}
+
+	// The restored store makes the same decisions.
+	restored.now = clock.Now
+	if trySend(&restored, "sre@example.com", "disk full", 0, 10*time.Minute) {
+		t.Fatal("restored error not suppressed")
+	}
+	if trySend(&restored, "ops@example.com", "new error", time.Minute, 10*time.Minute) {
+		t.Fatal("restored recipient limit not applied")
+	}
+	if n, _ := restored.suppressed("disk full"); n != 2 {
+		t.Fatalf("got %d suppressed, want 2", n)
+	}
+	if thread := restored.thread("queue stuck"); thread != "<queue stuck>" {
+		t.Fatalf("got thread %q", thread)
+	}
+}
+
+func TestDedupStoreJSONVersion(t *testing.T) {
+	var store DedupStore
+	if err := json.Unmarshal([]byte(`{"version":2,"errors":{}}`), &store); err == nil {
+		t.Fatal("newer format accepted")
+	}
+
+	// Dumps from before the version field are version 1.
+	if err := json.Unmarshal([]byte(`{"errors":{"disk full":{"sent":"2024-01-01T12:00:00Z","thread":"<t>"}}}`), &store); err != nil {
+		t.Fatal(err)
+	}
+	if thread := store.thread("disk full"); thread != "<t>" {
+		t.Fatalf("got thread %q", thread)
+	}
+	dump, err := json.Marshal(&store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(dump, &payload); err != nil || payload.Version != dedupFormatVersion {
+		t.Fatalf("got version %d, %v", payload.Version, err)
+	}
+}
@@ -0,0 +1,173 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithDigest collects entries for window and sends them as a single email with
+// a count per distinct message, one per recipient set, see WithRecipientsFor and
+// WithRecipientFunc. Panic and fatal entries are sent immediately.
+// Close the hook to send the pending digest.
+func WithDigest(window time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if window <= 0 {
+			return fmt.Errorf("digest window must be positive, got %s", window)
+		}
+		o.digestWindow = window
+		return nil
+	}
+}
+
+// digestGroup is a distinct message in a digest.
+type digestGroup struct {
+	first BodyData
+	count int
+}
+
+// digestBatch is the part of a digest going to one recipient set, see WithRecipientsFor.
+type digestBatch struct {
+	recipients []string
+	groups     map[string]*digestGroup
+	order      []string
+}
+
+// digest accumulates entries until its window elapses.
+type digest struct {
+	window time.Duration
+	send   func(message []byte, recipients []string) error
+	render func(groups []*digestGroup, since time.Time, recipients []string) []byte
+	// report receives the errors of the digests sent by the timer.
+	report func(err error)
+
+	mu      sync.Mutex
+	batches map[string]*digestBatch
+	order   []string
+	since   time.Time
+	timer   *time.Timer
+}
+
+func newDigest(window time.Duration, render func([]*digestGroup, time.Time, []string) []byte, send func([]byte, []string) error, report func(error)) *digest {
+	return &digest{
+		window:  window,
+		render:  render,
+		send:    send,
+		report:  report,
+		batches: make(map[string]*digestBatch),
+	}
+}
+
+// bypassesDigest reports whether the entry must be sent right away.
+func bypassesDigest(entry *logrus.Entry) bool {
+	return entry.Level <= logrus.FatalLevel
+}
+
+// add counts the entry under its message in the digest of its recipients. Only the first
+// occurrence of a message is shown, so body is called for that one alone.
+func (d *digest) add(message string, recipients []string, body func() BodyData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := recipientsKey(recipients)
+	batch, ok := d.batches[key]
+	if !ok {
+		batch = &digestBatch{recipients: recipients, groups: make(map[string]*digestGroup)}
+		d.batches[key] = batch
+		d.order = append(d.order, key)
+	}
+	if group, ok := batch.groups[message]; ok {
+		group.count++
+		return
+	}
+
+	data := body()
+	// The entry may be reused by logrus after Fire.
+	fields := make(logrus.Fields, len(data.Data))
+	for k, v := range data.Data {
+		fields[k] = v
+	}
+	data.Data = fields
+
+	batch.groups[message] = &digestGroup{first: data, count: 1}
+	batch.order = append(batch.order, message)
+	if d.timer == nil {
+		d.since = time.Now()
+		d.timer = time.AfterFunc(d.window, func() {
+			if err := d.flush(); err != nil {
+				d.report(err)
+			}
+		})
+	}
+}
+
+// flush sends the collected entries, if any, one email per recipient set.
+func (d *digest) flush() error {
+	d.mu.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	batches := make([]*digestBatch, 0, len(d.order))
+	for _, key := range d.order {
+		batches = append(batches, d.batches[key])
+	}
+	since := d.since
+	d.batches = make(map[string]*digestBatch)
+	d.order = nil
+	d.mu.Unlock()
+
+	var errs []error
+	for _, batch := range batches {
+		groups := make([]*digestGroup, 0, len(batch.order))
+		for _, key := range batch.order {
+			groups = append(groups, batch.groups[key])
+		}
+		errs = append(errs, d.send(d.render(groups, since, batch.recipients), batch.recipients))
+	}
+	return errors.Join(errs...)
+}
+
+// digestMessage renders the digest email.
+func (m *mailer) digestMessage(groups []*digestGroup, since time.Time, recipients []string) []byte {
+	total := 0
+	level := logrus.TraceLevel
+	for _, group := range groups {
+		total += group.count
+		if groupLevel, err := logrus.ParseLevel(group.first.Level); err == nil && groupLevel < level {
+			level = groupLevel
+		}
+	}
+
+	var body strings.Builder
+	now := time.Now()
+	fmt.Fprintf(&body, "DIGEST: %d entries, %d distinct, from %s to %s\n",
+		total, len(groups), m.formatTime(since), m.formatTime(now))
+	fmt.Fprintf(&body, "HOST: %s (pid %d)\n", m.hostname, m.pid)
+	if m.options.environment != "" {
+		fmt.Fprintf(&body, "ENV: %s\n", m.options.environment)
+	}
+	for _, group := range groups {
+		fmt.Fprintf(&body, "\n[%dx] %s: %s\n", group.count, group.first.Level, group.first.Message)
+		fmt.Fprintf(&body, "FIRST TIME: %s\n", group.first.FormattedTime)
+		fmt.Fprintf(&body, "DATA: %s\n", jsonIndent(group.first.Data))
+	}
+
+	subject := fmt.Sprintf("%s - digest: %d entries", m.appName, total)
+	header := headerData{recipients: recipients, subject: subject, date: now, level: level}
+	return m.assemble(header, textPart("text/plain; charset=utf-8", body.String())).Bytes()
+}
+
+// submitTo sends a rendered message through the queue when async, directly otherwise.
+func (m *mailer) submitTo(message []byte, recipients []string) error {
+	if m.queue != nil {
+		m.queue.push(outgoing{message: message, recipients: recipients})
+		return nil
+	}
+	return m.deliver(context.Background(), outgoing{message: message, recipients: recipients})
+}
@@ -0,0 +1,125 @@
+package log_hooks
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDigestBuildsFirstOccurrenceOnly(t *testing.T) {
+	d := newDigest(time.Hour, func(groups []*digestGroup, _ time.Time, _ []string) []byte {
+		var text string
+		for _, group := range groups {
+			text += group.first.Message + " "
+		}
+		return []byte(text)
+	}, func([]byte, []string) error { return nil }, func(error) {})
+
+	built := 0
+	body := func(message string) func() BodyData {
+		return func() BodyData {
+			built++
+			return BodyData{Message: message}
+		}
+	}
+	for _, message := range []string{"upstream down", "upstream down", "disk full", "upstream down"} {
+		d.add(message, []string{"ops@example.com"}, body(message))
+	}
+	if built != 2 {
+		t.Fatalf("built %d bodies, want 2", built)
+	}
+	batch := d.batches["ops@example.com"]
+	if count := batch.groups["upstream down"].count; count != 3 {
+		t.Fatalf("got %d occurrences, want 3", count)
+	}
+	if err := d.flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	hook := newDryRunHook(t, WithDigest(50*time.Millisecond))
+	for _, message := range []string{"upstream down", "disk full", "upstream down", "upstream down"} {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(hook.DryRunMessages()); n != 0 {
+		t.Fatalf("got %d messages before the window closed", n)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.DryRunMessages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	messages := hook.DryRunMessages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages after the window, want 1", len(messages))
+	}
+	msg := parseMessage(t, string(messages[0]))
+	if subject := msg.Header.Get("Subject"); subject != "app - digest: 4 entries" {
+		t.Fatalf("got subject %q", subject)
+	}
+	body := decodedBody(t, msg)
+	upstream, disk := strings.Index(body, "[3x] error: upstream down"), strings.Index(body, "[1x] error: disk full")
+	if !strings.Contains(body, "DIGEST: 4 entries, 2 distinct") || upstream < 0 || disk < upstream {
+		t.Fatalf("got digest:\n%s", body)
+	}
+}
+
+func TestDigestBypass(t *testing.T) {
+	hook := newDryRunHook(t, WithDigest(time.Hour))
+	for _, level := range []logrus.Level{logrus.ErrorLevel, logrus.PanicLevel, logrus.FatalLevel} {
+		if err := hook.Fire(testEntry(level, level.String()+" entry")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Panic and fatal entries are sent right away, as logrus doesn't return after them.
+	messages := hook.DryRunMessages()
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want the panic and the fatal entry", len(messages))
+	}
+	for i, want := range []string{"MESSAGE: panic entry", "MESSAGE: fatal entry"} {
+		if body := decodedBody(t, parseMessage(t, string(messages[i]))); !strings.Contains(body, want) {
+			t.Fatalf("message %d doesn't contain %s:\n%s", i+1, want, body)
+		}
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages = hook.DryRunMessages()
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want the digest of the error too", len(messages))
+	}
+	if body := decodedBody(t, parseMessage(t, string(messages[2]))); !strings.Contains(body, "[1x] error: error entry") {
+		t.Fatalf("got digest:\n%s", body)
+	}
+}
+
+func TestDigestSentOnClose(t *testing.T) {
+	hook := newDryRunHook(t, WithDigest(time.Hour))
+	if err := hook.Fire(testEntry(logrus.WarnLevel, "slow request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages := hook.DryRunMessages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want the pending digest", len(messages))
+	}
+	if body := decodedBody(t, parseMessage(t, string(messages[0]))); !strings.Contains(body, "[1x] warning: slow request") {
+		t.Fatalf("got digest:\n%s", body)
+	}
+	// Closing again doesn't send it twice.
+	if err := hook.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+	if n := len(hook.DryRunMessages()); n != 1 {
+		t.Fatalf("got %d messages after the second Close", n)
+	}
+}
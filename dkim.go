@@ -0,0 +1,149 @@
+package log_hooks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed when present in the message.
+var dkimHeaders = []string{"From", "To", "Cc", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// WithDKIM signs outgoing messages with DKIM (relaxed/relaxed canonicalization).
+// The key must be an *rsa.PrivateKey or an ed25519.PrivateKey. If signing fails
+// the message is sent unsigned.
+func WithDKIM(domain string, selector string, key crypto.Signer) MailOption {
+	return func(o *mailOptions) error {
+		if domain == "" || selector == "" {
+			return errors.New("dkim domain and selector must not be empty")
+		}
+
+		var algorithm string
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			algorithm = "rsa-sha256"
+		case ed25519.PrivateKey:
+			algorithm = "ed25519-sha256"
+		default:
+			return fmt.Errorf("unsupported dkim key type %T", key)
+		}
+
+		o.dkim = &dkimSigner{domain: domain, selector: selector, key: key, algorithm: algorithm}
+		return nil
+	}
+}
+
+type dkimSigner struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+}
+
+// sign returns the message with a DKIM-Signature header prepended.
+func (d *dkimSigner) sign(message []byte) ([]byte, error) {
+	end := bytes.Index(message, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, errors.New("dkim: message has no header block")
+	}
+	fields := splitHeaderFields(string(message[:end+2]))
+	body := message[end+4:]
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var canonical strings.Builder
+	for _, name := range dkimHeaders {
+		for _, field := range fields {
+			if strings.EqualFold(headerName(field), name) {
+				signed = append(signed, strings.ToLower(name))
+				canonical.WriteString(relaxedHeader(field) + "\r\n")
+				break
+			}
+		}
+	}
+
+	value := "v=1; a=" + d.algorithm + "; c=relaxed/relaxed; d=" + d.domain + "; s=" + d.selector +
+		"; t=" + strconv.FormatInt(time.Now().Unix(), 10) +
+		"; h=" + strings.Join(signed, ":") +
+		"; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b="
+	canonical.WriteString(relaxedHeader("DKIM-Signature: " + value))
+
+	hash := sha256.Sum256([]byte(canonical.String()))
+	var signature []byte
+	var err error
+	if d.algorithm == "ed25519-sha256" {
+		signature, err = d.key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	} else {
+		signature, err = d.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	var header strings.Builder
+	writeHeader(&header, "DKIM-Signature", value+base64.StdEncoding.EncodeToString(signature))
+	return append([]byte(header.String()), message...), nil
+}
+
+// splitHeaderFields splits a CRLF-terminated header block into fields, keeping folding.
+func splitHeaderFields(block string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(block, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSuffix(fields[i], "\r\n")
+	}
+	return fields
+}
+
+func headerName(field string) string {
+	if i := strings.IndexByte(field, ':'); i >= 0 {
+		return strings.TrimSpace(field[:i])
+	}
+	return field
+}
+
+// relaxedHeader canonicalizes a header field per RFC 6376 3.4.2.
+func relaxedHeader(field string) string {
+	i := strings.IndexByte(field, ':')
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.NewReplacer("\r\n", "").Replace(field[i+1:])
+	return name + ":" + strings.Join(strings.Fields(value), " ")
+}
+
+// relaxedBody canonicalizes a body per RFC 6376 3.4.4. Lone LFs are treated as
+// CRLF, the SMTP DATA writer converts them on the wire.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && lines[i] != "" {
+			lines[i] = " " + lines[i]
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
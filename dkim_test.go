@@ -0,0 +1,183 @@
+package log_hooks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The example of RFC 6376 3.4.5.
+func TestRelaxedCanonicalization(t *testing.T) {
+	var headers []string
+	for _, field := range splitHeaderFields("A: X\r\nB : Y\t\r\n\tZ  \r\n") {
+		headers = append(headers, relaxedHeader(field))
+	}
+	if got := strings.Join(headers, "\r\n"); got != "a:X\r\nb:Y Z" {
+		t.Fatalf("got headers %q", got)
+	}
+	if got := string(relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))); got != " C\r\nD E\r\n" {
+		t.Fatalf("got body %q", got)
+	}
+}
+
+var dkimTag = regexp.MustCompile(`(?:^|;)\s*([a-z]+)=([^;]*)`)
+
+// verifyDKIM checks the DKIM-Signature of a raw message like a receiving server, returning the tags.
+func verifyDKIM(message []byte, public crypto.PublicKey) (map[string]string, error) {
+	header, body, ok := strings.Cut(string(message), "\r\n\r\n")
+	if !ok {
+		return nil, errors.New("no header block")
+	}
+	fields := splitHeaderFields(header + "\r\n")
+	if headerName(fields[0]) != "DKIM-Signature" {
+		return nil, errors.New("not signed")
+	}
+	_, value, _ := strings.Cut(fields[0], ":")
+	tags := make(map[string]string)
+	for _, match := range dkimTag.FindAllStringSubmatch(value, -1) {
+		tags[match[1]] = strings.Join(strings.Fields(match[2]), "")
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody([]byte(body)))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return nil, errors.New("body hash mismatch")
+	}
+	var canonical strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for _, field := range fields[1:] {
+			if strings.EqualFold(headerName(field), name) {
+				canonical.WriteString(relaxedHeader(field) + "\r\n")
+				break
+			}
+		}
+	}
+	// The signature itself is hashed with an empty b= tag.
+	unsigned := regexp.MustCompile(`(;\s*b=)[^;]*$`).ReplaceAllString(fields[0], "$1")
+	canonical.WriteString(relaxedHeader(unsigned))
+	hash := sha256.Sum256([]byte(canonical.String()))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return nil, err
+	}
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, hash[:], signature) {
+			err = errors.New("ed25519 verification failed")
+		}
+	}
+	return tags, err
+}
+
+func TestDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key       crypto.Signer
+		algorithm string
+	}{
+		{rsaKey, "rsa-sha256"},
+		{ed25519Key, "ed25519-sha256"},
+	} {
+		key := test.key
+		hook := newDryRunHook(t, WithDKIM("example.com", "alerts", key))
+		entry := testEntry(logrus.ErrorLevel, "signed   message \t with  spaces")
+		entry.Data["query"] = "select 1   "
+		fireMessage(t, hook, entry)
+		message := hook.DryRunMessages()[0]
+
+		tags, err := verifyDKIM(message, key.Public())
+		if err != nil {
+			t.Fatalf("%T: %v\n%s", key, err, message)
+		}
+		if tags["a"] != test.algorithm || tags["c"] != "relaxed/relaxed" || tags["d"] != "example.com" || tags["s"] != "alerts" {
+			t.Fatalf("got tags %v", tags)
+		}
+		if !strings.HasPrefix(tags["h"], "from:to:subject:date:") {
+			t.Fatalf("got signed headers %q", tags["h"])
+		}
+
+		// Changes in transit break the signature, whitespace doesn't under relaxed canonicalization.
+		respaced := strings.Replace(string(message), "\r\nSubject: ", "\r\nSubject:   ", 1)
+		if _, err := verifyDKIM([]byte(respaced), key.Public()); err != nil {
+			t.Fatalf("%T: respaced subject: %v", key, err)
+		}
+		tampered := strings.Replace(string(message), "Subject: app", "Subject: spam", 1)
+		if _, err := verifyDKIM([]byte(tampered), key.Public()); err == nil {
+			t.Fatalf("%T: tampered subject verified", key)
+		}
+	}
+}
+
+func TestDKIMSigningFailure(t *testing.T) {
+	// A key this small can't hold a SHA-256 signature.
+	tiny := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: big.NewInt(3233), E: 17},
+		D:         big.NewInt(2753),
+		Primes:    []*big.Int{big.NewInt(61), big.NewInt(53)},
+	}
+	var mu sync.Mutex
+	var reported []error
+	hook := newDryRunHook(t, WithDKIM("example.com", "alerts", tiny), WithOnError(func(_ *logrus.Entry, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	}))
+
+	msg := fireMessage(t, hook, testEntry(logrus.ErrorLevel, "unsigned"))
+	if signature := msg.Header.Get("DKIM-Signature"); signature != "" {
+		t.Fatalf("got DKIM-Signature %q", signature)
+	}
+	if body := decodedBody(t, msg); !strings.Contains(body, "MESSAGE: unsigned") {
+		t.Fatalf("got body %q", body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || !strings.HasPrefix(reported[0].Error(), "dkim: ") {
+		t.Fatalf("got reported %v", reported)
+	}
+
+	for _, test := range []struct {
+		domain, selector string
+		key              crypto.Signer
+	}{
+		{"", "alerts", tiny},
+		{"example.com", "", tiny},
+		{"example.com", "alerts", fakeSigner{}},
+	} {
+		if _, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+			WithoutConnectivityCheck(), WithDKIM(test.domain, test.selector, test.key)); err == nil {
+			t.Fatalf("WithDKIM(%q, %q, %T) succeeded", test.domain, test.selector, test.key)
+		}
+	}
+}
+
+// fakeSigner is a crypto.Signer of a key type DKIM doesn't define.
+type fakeSigner struct{}
+
+func (fakeSigner) Public() crypto.PublicKey { return nil }
+
+func (fakeSigner) Sign(_ io.Reader, _ []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("not a key")
+}
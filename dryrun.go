@@ -0,0 +1,43 @@
+package log_hooks
+
+import (
+	"io"
+	"sync"
+)
+
+// WithDryRun does everything but the SMTP send: messages are written to w
+// (nil discards them) and recorded, see DryRunMessages.
+func WithDryRun(w io.Writer) MailOption {
+	return func(o *mailOptions) error {
+		if w == nil {
+			w = io.Discard
+		}
+		o.dryRun = w
+		return nil
+	}
+}
+
+// dryRunLog records the messages of a dry-run hook.
+type dryRunLog struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+// DryRunMessages returns the messages a dry-run hook would have sent, oldest first.
+func (m *mailer) DryRunMessages() [][]byte {
+	m.dryRunLog.mu.Lock()
+	defer m.dryRunLog.mu.Unlock()
+
+	messages := make([][]byte, len(m.dryRunLog.messages))
+	copy(messages, m.dryRunLog.messages)
+	return messages
+}
+
+func (m *mailer) dryRunSend(out outgoing) error {
+	m.dryRunLog.mu.Lock()
+	defer m.dryRunLog.mu.Unlock()
+
+	m.dryRunLog.messages = append(m.dryRunLog.messages, out.message)
+	_, err := m.options.dryRun.Write(out.message)
+	return err
+}
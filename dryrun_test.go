@@ -0,0 +1,58 @@
+package log_hooks
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDryRun(t *testing.T) {
+	server := newTestServer(t, nil)
+	var out bytes.Buffer
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithDryRun(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "staging only")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The duplicate is suppressed like a real send would be.
+	messages := hook.DryRunMessages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if !bytes.Equal(out.Bytes(), messages[0]) {
+		t.Fatalf("writer got %q, recorded %q", out.Bytes(), messages[0])
+	}
+	msg := parseMessage(t, string(messages[0]))
+	if to := msg.Header.Get("To"); to != "ops@example.com" {
+		t.Fatalf("got To %q", to)
+	}
+	if n := server.connections.Load(); n != 0 {
+		t.Fatalf("got %d connections, want none", n)
+	}
+	if err := hook.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDryRunDiscard(t *testing.T) {
+	hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithDryRun(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "discarded")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(hook.DryRunMessages()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,108 @@
+package log_hooks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// Endpoint is an SMTP server to fall back to when the hook host fails.
+type Endpoint struct {
+	Host string
+	Port int
+	// Username and Password enable authentication on this endpoint.
+	Username string
+	Password string
+}
+
+// WithFallback adds servers tried in order when the hook host fails.
+// The server that worked last is tried first on the next send.
+func WithFallback(endpoints ...Endpoint) MailOption {
+	return func(o *mailOptions) error {
+		for _, ep := range endpoints {
+			if ep.Host == "" || ep.Port <= 0 || ep.Port > 65535 {
+				return fmt.Errorf("invalid fallback endpoint %s", net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port)))
+			}
+			if (ep.Username == "") != (ep.Password == "") {
+				return fmt.Errorf("fallback endpoint %s: %w", net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port)), ErrEmptyCredentials)
+			}
+		}
+		o.fallbacks = append(o.fallbacks, endpoints...)
+		return nil
+	}
+}
+
+// endpoint is a server the hook sends through.
+type endpoint struct {
+	host      string
+	port      int
+	username  string
+	password  string
+	tlsConfig *tls.Config
+	// pool keeps connections between sends, see WithConnectionPool.
+	pool *connPool
+}
+
+func newEndpoint(ep Endpoint, options mailOptions) *endpoint {
+	tlsConfig := options.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = ep.Host
+	}
+
+	e := &endpoint{
+		host:      ep.Host,
+		port:      ep.Port,
+		username:  ep.Username,
+		password:  ep.Password,
+		tlsConfig: tlsConfig,
+	}
+	if options.poolSize > 0 {
+		e.pool = newConnPool(options.poolSize, options.poolIdleTimeout)
+	}
+	return e
+}
+
+func (e *endpoint) String() string {
+	return net.JoinHostPort(e.host, strconv.Itoa(e.port))
+}
+
+// send delivers a rendered message through the sender of the hook.
+func (m *mailer) send(ctx context.Context, out outgoing) error {
+	if m.options.dryRun != nil {
+		return m.dryRunSend(out)
+	}
+	err := m.transport.Send(ctx, m.from.Address, m.envelopeRecipients(out.recipients), out.message)
+	// SMTP counts the failures of each endpoint itself.
+	if _, ok := m.transport.(smtpSender); err != nil && !ok {
+		m.stats.dataErrors.Add(1)
+	}
+	return err
+}
+
+// sendEndpoints delivers a rendered message through the first endpoint that accepts it,
+// starting with the one that worked last.
+func (m *mailer) sendEndpoints(ctx context.Context, from string, to []string, message []byte) error {
+	start := int(atomic.LoadInt32(&m.preferred))
+
+	var errs []error
+	for i := range m.endpoints {
+		index := (start + i) % len(m.endpoints)
+		ep := m.endpoints[index]
+
+		err := m.sendTo(ctx, ep, from, to, message)
+		var partial *partialDeliveryError
+		if err == nil || errors.As(err, &partial) {
+			atomic.StoreInt32(&m.preferred, int32(index))
+			return err
+		}
+		if len(m.endpoints) == 1 {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", ep, err))
+	}
+	return errors.Join(errs...)
+}
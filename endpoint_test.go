@@ -0,0 +1,75 @@
+package log_hooks
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFallback(t *testing.T) {
+	primary, backup := newTestServer(t, nil), newTestServer(t, nil)
+	_ = primary.ln.Close()
+	hook, err := NewMailHook("app", "127.0.0.1", primary.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithFallback(Endpoint{Host: "127.0.0.1", Port: backup.port()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "primary down")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(backup.received()); n != 1 {
+		t.Fatalf("got %d messages on the backup, want 1", n)
+	}
+}
+
+func TestFallbackPrefersLastWorking(t *testing.T) {
+	primary := newTestServer(t, func(s *testServer) {
+		s.rejectData.Store(1)
+		s.rejectReply = "451 4.3.0 try again later"
+	})
+	backup := newTestServer(t, nil)
+	hook, err := NewMailHook("app", "127.0.0.1", primary.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithoutDedup(), WithFallback(Endpoint{Host: "127.0.0.1", Port: backup.port()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range []string{"first", "second"} {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(backup.received()); n != 2 {
+		t.Fatalf("got %d messages on the backup, want 2", n)
+	}
+	// The second send didn't try the primary again.
+	if n := primary.connections.Load(); n != 1 {
+		t.Fatalf("got %d connections to the primary, want 1", n)
+	}
+}
+
+func TestFallbackErrors(t *testing.T) {
+	primary := newTestServer(t, func(s *testServer) { s.rejectData.Store(1) })
+	backup := newTestServer(t, nil)
+	_ = backup.ln.Close()
+	hook, err := NewMailHook("app", "127.0.0.1", primary.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithFallback(Endpoint{Host: "127.0.0.1", Port: backup.port()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every endpoint shows up in the error with its own failure.
+	err = hook.Fire(testEntry(logrus.ErrorLevel, "all down"))
+	if err == nil {
+		t.Fatal("sent with all endpoints failing")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], fmt.Sprintf("127.0.0.1:%d: ", primary.port())) || !strings.Contains(lines[0], "554") ||
+		!strings.HasPrefix(lines[1], fmt.Sprintf("127.0.0.1:%d: ", backup.port())) || !strings.Contains(lines[1], "connection refused") {
+		t.Fatalf("got %q", err)
+	}
+}
@@ -0,0 +1,54 @@
+package log_hooks
+
+import (
+	"errors"
+	"runtime/debug"
+	"strings"
+)
+
+// WithEnvironment names the environment the hook runs in, e.g. "prod", for the ENV line of the
+// body and the Environment of the subject template.
+func WithEnvironment(name string) MailOption {
+	return func(o *mailOptions) error {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("environment must not be empty")
+		}
+		o.environment = name
+		return nil
+	}
+}
+
+// buildDescription describes the main module of the binary as "path@version (rev …)",
+// it is empty without build info.
+func buildDescription() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Path == "" {
+		return ""
+	}
+	desc := info.Main.Path
+	if version := info.Main.Version; version != "" && version != "(devel)" {
+		desc += "@" + version
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" {
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		desc += " (rev " + revision
+		if modified {
+			desc += ", modified"
+		}
+		desc += ")"
+	}
+	return desc
+}
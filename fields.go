@@ -0,0 +1,97 @@
+package log_hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrConflictingFieldFilters is returned when both WithFields and WithoutFields are used.
+var ErrConflictingFieldFilters = errors.New("WithFields and WithoutFields can't be used together")
+
+// WithFields includes only the fields matching any of patterns in the DATA section and
+// notes how many were left out. Patterns are case-insensitive globs like "request_*".
+func WithFields(patterns ...string) MailOption {
+	return func(o *mailOptions) error {
+		return o.setFieldFilter(true, patterns)
+	}
+}
+
+// WithoutFields leaves the fields matching any of patterns out of the DATA section and
+// notes how many were left out. Patterns are case-insensitive globs like "span_*".
+func WithoutFields(patterns ...string) MailOption {
+	return func(o *mailOptions) error {
+		return o.setFieldFilter(false, patterns)
+	}
+}
+
+func (o *mailOptions) setFieldFilter(include bool, patterns []string) error {
+	if o.fieldPatterns != nil && o.includeFields != include {
+		return ErrConflictingFieldFilters
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid field pattern %q: %w", pattern, err)
+		}
+		o.fieldPatterns = append(o.fieldPatterns, pattern)
+	}
+	if o.fieldPatterns == nil {
+		o.fieldPatterns = []string{}
+	}
+	o.includeFields = include
+	return nil
+}
+
+// filterFields applies WithFields or WithoutFields to the top level fields and returns
+// how many were left out.
+func (m *mailer) filterFields(fields logrus.Fields) (logrus.Fields, int) {
+	if m.options.fieldPatterns == nil {
+		return fields, 0
+	}
+	filtered := make(logrus.Fields, len(fields))
+	for key, value := range fields {
+		if matchesField(m.options.fieldPatterns, key) == m.options.includeFields {
+			filtered[key] = value
+		}
+	}
+	return filtered, len(fields) - len(filtered)
+}
+
+// matchesField reports whether the key matches any of the lower-cased glob patterns.
+func matchesField(patterns []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeFields returns a copy of fields that marshals to JSON. Errors are replaced by their
+// message, as they marshal to "{}", and the values encoding/json rejects, like channels,
+// funcs and cycles, by their %+v rendering. It also returns the keys of the latter, sorted.
+func encodeFields(fields logrus.Fields) (logrus.Fields, []string) {
+	encoded := make(logrus.Fields, len(fields))
+	var failed []string
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			if _, marshals := value.(json.Marshaler); !marshals {
+				value = err.Error()
+			}
+		}
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprintf("%+v", value)
+			failed = append(failed, key)
+		}
+		encoded[key] = value
+	}
+	sort.Strings(failed)
+	return encoded, failed
+}
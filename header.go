@@ -0,0 +1,87 @@
+package log_hooks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reservedHeaders are generated by the hook and can only be replaced with WithHeaderOverride.
+var reservedHeaders = []string{"From", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID", "In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding", "X-Priority", "Importance"}
+
+type headerField struct {
+	name  string
+	value string
+}
+
+// WithHeader adds a header to every outgoing message, e.g. X-Team or
+// X-Auto-Response-Suppress. Headers generated by the hook can't be set this way.
+func WithHeader(name string, value string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkHeader(name, value); err != nil {
+			return err
+		}
+		if isReservedHeader(name) {
+			return fmt.Errorf("header %q is generated by the hook, use WithHeaderOverride to replace it", name)
+		}
+		o.headers = append(o.headers, headerField{name, value})
+		return nil
+	}
+}
+
+// WithHeaderOverride replaces the value of a header generated by the hook,
+// or adds the header like WithHeader.
+func WithHeaderOverride(name string, value string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkHeader(name, value); err != nil {
+			return err
+		}
+		if !isReservedHeader(name) {
+			o.headers = append(o.headers, headerField{name, value})
+			return nil
+		}
+		o.headerOverrides = append(o.headerOverrides, headerField{name, value})
+		return nil
+	}
+}
+
+func checkHeader(name string, value string) error {
+	if name == "" {
+		return fmt.Errorf("header name must not be empty")
+	}
+	for _, r := range name {
+		// RFC 5322 field names are printable ASCII except the colon.
+		if r <= ' ' || r > '~' || r == ':' {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %q value must not contain CR or LF", name)
+	}
+	return nil
+}
+
+func isReservedHeader(name string) bool {
+	for _, reserved := range reservedHeaders {
+		if strings.EqualFold(name, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCustomHeaders replaces overridden fields and appends the custom ones.
+func (m *mailer) applyCustomHeaders(fields []headerField) []headerField {
+	for _, override := range m.options.headerOverrides {
+		replaced := false
+		for i := range fields {
+			if strings.EqualFold(fields[i].name, override.name) {
+				fields[i].value = override.value
+				replaced = true
+			}
+		}
+		if !replaced {
+			fields = append(fields, override)
+		}
+	}
+	return append(fields, m.options.headers...)
+}
@@ -0,0 +1,71 @@
+package log_hooks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// headerBlock returns the header lines of a raw message.
+func headerBlock(message []byte) []string {
+	header, _, _ := strings.Cut(string(message), "\r\n\r\n")
+	return strings.Split(header, "\r\n")
+}
+
+func TestHeaderInjection(t *testing.T) {
+	hostile := []string{
+		"boom\r\nBcc: attacker@example.com",
+		"boom\nBcc: attacker@example.com",
+		"boom\rBcc: attacker@example.com",
+		"boom\r\n\r\nBcc: attacker@example.com",
+		"boom\r\n Bcc: attacker@example.com",
+	}
+	for _, message := range hostile {
+		hook := newDryRunHook(t, WithSubjectTemplate("{{.Message}} {{.Fields.user}}"))
+		entry := testEntry(logrus.ErrorLevel, message)
+		entry.Data["user"] = "x\r\nCc: attacker@example.com"
+
+		msg := fireMessage(t, hook, entry)
+		for _, name := range []string{"Bcc", "Cc"} {
+			if _, ok := msg.Header[name]; ok {
+				t.Fatalf("%q: injected %s header %q", message, name, msg.Header.Get(name))
+			}
+		}
+		for _, line := range headerBlock(hook.DryRunMessages()[0]) {
+			if strings.Contains(line, "attacker") && !strings.HasPrefix(line, "Subject: ") {
+				t.Fatalf("%q: injected header line %q", message, line)
+			}
+		}
+		if subject := decodedSubject(t, msg); strings.ContainsAny(subject, "\r\n") {
+			t.Fatalf("%q: got subject %q", message, subject)
+		}
+	}
+}
+
+func TestHeaderValueRejected(t *testing.T) {
+	for name, option := range map[string]MailOption{
+		"header":          WithHeader("X-Team", "ops\r\nBcc: attacker@example.com"),
+		"header override": WithHeaderOverride("Subject", "alert\nBcc: attacker@example.com"),
+		"header name":     WithHeader("X-Team\r\nBcc", "ops"),
+		"reply-to":        WithReplyTo("Ops\r\nBcc: attacker@example.com <ops@example.com>"),
+	} {
+		_, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", WithoutConnectivityCheck(), option)
+		if err == nil {
+			t.Errorf("%s: CR or LF accepted", name)
+		}
+	}
+}
+
+func TestHeaderInjectionInDisplayName(t *testing.T) {
+	hook := newDryRunHook(t, WithReplyTo(`"Ops =?utf-8?q?=0D=0ABcc:_attacker@example.com?=" <ops@example.com>`))
+	msg := fireMessage(t, hook, testEntry(logrus.ErrorLevel, "display name"))
+	if _, ok := msg.Header["Bcc"]; ok {
+		t.Fatalf("injected Bcc header %q", msg.Header.Get("Bcc"))
+	}
+	for _, line := range headerBlock(hook.DryRunMessages()[0]) {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Fatalf("injected header line %q", line)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HistoryHook records the last entries of all levels, so that emails can show what was
+// logged before an error, see WithHistory. It is safe for concurrent use.
+type HistoryHook struct {
+	formatter logrus.Formatter
+
+	mu sync.Mutex
+	// records is a ring buffer, next is the index of the oldest record once it is full.
+	records []historyRecord
+	next    int
+	seq     uint64
+}
+
+// historyRecord keeps a copy of the entry, as logrus reuses entries, and formats it once
+// an email shows it, so that it can be redacted.
+type historyRecord struct {
+	seq    uint64
+	source *logrus.Entry
+	entry  *logrus.Entry
+}
+
+// NewHistoryHook creates a hook keeping the last size entries, formatted by formatter
+// or as text without colors when it is nil.
+func NewHistoryHook(size int, formatter logrus.Formatter) (*HistoryHook, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("history size must be positive, got %d", size)
+	}
+	if formatter == nil {
+		formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	}
+	return &HistoryHook{formatter: formatter, records: make([]historyRecord, 0, size)}, nil
+}
+
+// WithHistory includes the entries recorded by history in the HISTORY section of the
+// emails. Each email shows the entries recorded since the previous email of the hook,
+// as far as they are still in the history, but not the entry it is sent for.
+// Their fields and messages are redacted like those of the entry, see WithRedactedFields
+// and WithMessageRedaction.
+func WithHistory(history *HistoryHook) MailOption {
+	return func(o *mailOptions) error {
+		if history == nil {
+			return errors.New("history hook must not be nil")
+		}
+		o.history = history
+		return nil
+	}
+}
+
+func (h *HistoryHook) Fire(entry *logrus.Entry) error {
+	dup := copyEntry(entry)
+	// The buffer goes back to the pool of logrus after the hooks.
+	dup.Buffer = nil
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	record := historyRecord{seq: h.seq, source: entry, entry: dup}
+	if len(h.records) < cap(h.records) {
+		h.records = append(h.records, record)
+		return nil
+	}
+	h.records[h.next] = record
+	h.next = (h.next + 1) % len(h.records)
+	return nil
+}
+
+// Levels returns all levels.
+func (h *HistoryHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// since returns the entries recorded after the record *cursor, oldest first, leaving out
+// the entry itself, and moves the cursor to the latest record.
+func (h *HistoryHook) since(cursor *uint64, entry *logrus.Entry) []*logrus.Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var entries []*logrus.Entry
+	for i := range h.records {
+		record := h.records[(h.next+i)%len(h.records)]
+		if record.seq > *cursor && record.source != entry {
+			entries = append(entries, record.entry)
+		}
+	}
+	*cursor = h.seq
+	return entries
+}
+
+// history returns the redacted history lines for the email about entry.
+func (m *mailer) history(entry *logrus.Entry) []string {
+	history := m.options.history
+	if history == nil {
+		return nil
+	}
+	var lines []string
+	for _, recorded := range history.since(&m.historySeq, entry) {
+		redacted := *recorded
+		redacted.Data = m.redactFields(recorded.Data)
+		redacted.Message = m.redactMessage(recorded.Message)
+		line, err := history.formatter.Format(&redacted)
+		if err != nil {
+			m.reportError(entry, fmt.Errorf("history: %w", err))
+			continue
+		}
+		lines = append(lines, strings.TrimRight(string(line), "\n"))
+	}
+	return lines
+}
@@ -0,0 +1,66 @@
+package log_hooks
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHistoryRedaction(t *testing.T) {
+	for _, attach := range []bool{false, true} {
+		history, err := NewHistoryHook(10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := []MailOption{WithHistory(history), WithRedactedFields("password", "*_token"),
+			WithMessageRedaction(regexp.MustCompile(`key=\w+`))}
+		if attach {
+			opts = append(opts, WithAttachments(0))
+		}
+		hook := newDryRunHook(t, opts...)
+		logger := logrus.New()
+		logger.Out = io.Discard
+		logger.Hooks.Add(history)
+		logger.Hooks.Add(hook)
+
+		logger.WithFields(logrus.Fields{"user": "alice", "password": "hunter2", "refresh_token": "r3fr3sh"}).
+			Info("login with key=s3cr3t")
+		logger.Error("payment failed")
+
+		messages := hook.DryRunMessages()
+		if len(messages) != 1 {
+			t.Fatalf("got %d messages, want 1", len(messages))
+		}
+		var text string
+		if attach {
+			text = attachedText(t, string(messages[0]), "history.txt")
+		} else {
+			text = decodedBody(t, parseMessage(t, string(messages[0])))
+		}
+		for _, secret := range []string{"hunter2", "r3fr3sh", "s3cr3t"} {
+			if strings.Contains(text, secret) {
+				t.Fatalf("attach %v: history shows %q:\n%s", attach, secret, text)
+			}
+		}
+		for _, want := range []string{"user=alice", "password=\"[REDACTED]\"", "login with [REDACTED]"} {
+			if !strings.Contains(text, want) {
+				t.Fatalf("attach %v: history doesn't contain %s:\n%s", attach, want, text)
+			}
+		}
+	}
+}
+
+// attachedText returns the decoded attachment of the multipart message.
+func attachedText(t *testing.T, message string, filename string) string {
+	t.Helper()
+	for _, part := range multipartBody(t, parseMessage(t, message), "multipart/mixed") {
+		if strings.Contains(part.header.Get("Content-Disposition"), `filename="`+filename+`"`) {
+			return part.body
+		}
+	}
+	t.Fatalf("no attachment %s", filename)
+	return ""
+}
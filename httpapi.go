@@ -0,0 +1,199 @@
+package log_hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRetryAfter caps the wait for a rate limited HTTP API, see HTTPAPISender.
+const DefaultMaxRetryAfter = time.Minute
+
+// maxRateLimitedAttempts bounds the requests of one send that are answered with 429.
+const maxRateLimitedAttempts = 3
+
+// APIEmail is an email as passed to the payload function of HTTPAPISender.
+type APIEmail struct {
+	From string
+	// To holds all envelope recipients, also cc and bcc.
+	To      []string
+	Subject string
+	// Text is the decoded plain text body, empty when the email has none.
+	Text string
+	// Message is the whole email as sent over SMTP.
+	Message []byte
+}
+
+// HTTPAPISender delivers emails by POSTing them to the HTTPS API of a provider like SendGrid
+// or Mailgun, see WithSender. Responses with 429 Too Many Requests are retried after the
+// delay of their Retry-After header, as long as it fits the context and MaxRetryAfter.
+// Other failures are returned as *HTTPAPIError.
+type HTTPAPISender struct {
+	// URL is the endpoint the emails are POSTed to.
+	URL string
+	// Header is added to every request, e.g. "Authorization: Bearer <api key>".
+	Header http.Header
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+	// Payload returns the request body and its content type for the provider, the email is
+	// sent as message/rfc822 when it is nil.
+	Payload func(email APIEmail) (body []byte, contentType string, err error)
+	// MaxRetryAfter is the longest Retry-After waited for, DefaultMaxRetryAfter when zero.
+	MaxRetryAfter time.Duration
+}
+
+// HTTPAPIError is an unsuccessful response of the HTTP API.
+type HTTPAPIError struct {
+	StatusCode int
+	// Body is the beginning of the response body.
+	Body string
+	// RetryAfter is the delay the API asked for, -1 when it didn't.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPAPIError) Error() string {
+	msg := "http api: " + http.StatusText(e.StatusCode) + " (" + strconv.Itoa(e.StatusCode) + ")"
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed later, for rate limits and server errors.
+func (e *HTTPAPIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (s *HTTPAPISender) Send(ctx context.Context, from string, to []string, message []byte) error {
+	body, contentType := message, "message/rfc822"
+	if s.Payload != nil {
+		var err error
+		body, contentType, err = s.Payload(newAPIEmail(from, to, message))
+		if err != nil {
+			return fmt.Errorf("http api payload: %w", err)
+		}
+	}
+
+	maxWait := s.MaxRetryAfter
+	if maxWait == 0 {
+		maxWait = DefaultMaxRetryAfter
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, body, contentType)
+		var apiErr *HTTPAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitedAttempts {
+			return err
+		}
+		wait := apiErr.RetryAfter
+		if wait < 0 || wait > maxWait {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *HTTPAPISender) post(ctx context.Context, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return &HTTPAPIError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(text)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date,
+// it returns -1 without a valid header.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return -1
+}
+
+func newAPIEmail(from string, to []string, message []byte) APIEmail {
+	email := APIEmail{From: from, To: to, Message: message}
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return email
+	}
+	decoder := new(mime.WordDecoder)
+	if subject, err := decoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		email.Subject = subject
+	}
+	email.Text = plainText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	return email
+}
+
+// plainText returns the decoded text/plain body, searching multipart bodies depth first.
+func plainText(contentType string, encoding string, body io.Reader) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				return ""
+			}
+			text := plainText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if text != "" && part.Header.Get("Content-Disposition") == "" {
+				return text
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return ""
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	text, _ := io.ReadAll(body)
+	return strings.ReplaceAll(string(text), "\r\n", "\n")
+}
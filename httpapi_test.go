@@ -0,0 +1,135 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testAPI is an HTTP API answering with the queued responses, 202 Accepted once they run out.
+type testAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	requests  []*http.Request
+	bodies    []string
+}
+
+func newTestAPI(t *testing.T, responses ...func(w http.ResponseWriter)) *testAPI {
+	t.Helper()
+	api := &testAPI{responses: responses}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		api.mu.Lock()
+		api.requests = append(api.requests, r)
+		api.bodies = append(api.bodies, string(body))
+		var respond func(w http.ResponseWriter)
+		if len(api.responses) > 0 {
+			respond, api.responses = api.responses[0], api.responses[1:]
+		}
+		api.mu.Unlock()
+		if respond == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		respond(w)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func status(code int, retryAfter string, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(code)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+func TestHTTPAPISender(t *testing.T) {
+	api := newTestAPI(t, status(http.StatusTooManyRequests, "1", "slow down"))
+	var email APIEmail
+	sender := &HTTPAPISender{
+		URL:    api.URL,
+		Header: http.Header{"Authorization": {"Bearer key"}},
+		Payload: func(e APIEmail) ([]byte, string, error) {
+			email = e
+			return []byte(`{"subject":"` + e.Subject + `"}`), "application/json", nil
+		},
+	}
+	hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", WithSender(sender))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "rate limited")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %s, before the Retry-After of 1s", elapsed)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(api.requests))
+	}
+	request := api.requests[1]
+	if request.Header.Get("Authorization") != "Bearer key" || request.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got header %v", request.Header)
+	}
+	if api.bodies[1] != `{"subject":"app - error"}` {
+		t.Fatalf("got body %q", api.bodies[1])
+	}
+	if email.From != "alerts@example.com" || len(email.To) != 1 || !strings.Contains(email.Text, "MESSAGE: rate limited") {
+		t.Fatalf("got email %+v", email)
+	}
+}
+
+func TestHTTPAPIError(t *testing.T) {
+	for _, test := range []struct {
+		respond    func(w http.ResponseWriter)
+		status     int
+		retryAfter time.Duration
+		temporary  bool
+	}{
+		{status(http.StatusBadRequest, "", "invalid from address\n"), http.StatusBadRequest, -1, false},
+		{status(http.StatusServiceUnavailable, "", ""), http.StatusServiceUnavailable, -1, true},
+		// A Retry-After longer than MaxRetryAfter isn't waited for.
+		{status(http.StatusTooManyRequests, "120", ""), http.StatusTooManyRequests, 2 * time.Minute, true},
+	} {
+		api := newTestAPI(t, test.respond)
+		sender := &HTTPAPISender{URL: api.URL}
+
+		err := sender.Send(context.Background(), "alerts@example.com", []string{"ops@example.com"}, []byte("Subject: test\r\n\r\nbody\r\n"))
+		var apiErr *HTTPAPIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("got %v, want an HTTPAPIError", err)
+		}
+		if apiErr.StatusCode != test.status || apiErr.RetryAfter != test.retryAfter || apiErr.Temporary() != test.temporary {
+			t.Fatalf("got %+v, temporary %v", apiErr, apiErr.Temporary())
+		}
+		if test.status == http.StatusBadRequest && err.Error() != "http api: Bad Request (400): invalid from address" {
+			t.Fatalf("got %q", err)
+		}
+		api.mu.Lock()
+		if len(api.requests) != 1 {
+			t.Fatalf("status %d: got %d requests, want 1", test.status, len(api.requests))
+		}
+		if contentType := api.requests[0].Header.Get("Content-Type"); contentType != "message/rfc822" {
+			t.Fatalf("got Content-Type %q", contentType)
+		}
+		api.mu.Unlock()
+	}
+}
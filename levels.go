@@ -0,0 +1,79 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithLevels sets the levels the mail hook sends emails for.
+func WithLevels(levels []logrus.Level) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkLevels(levels); err != nil {
+			return err
+		}
+		o.levels = append([]logrus.Level(nil), levels...)
+		return nil
+	}
+}
+
+// WithMinLevel sends emails for the level and all more severe ones.
+func WithMinLevel(level logrus.Level) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkLevels([]logrus.Level{level}); err != nil {
+			return err
+		}
+		o.levels = levelsUpTo(level)
+		return nil
+	}
+}
+
+// WithStderrLevels sets the levels the stderr hook prints, warn and more severe ones by default.
+func WithStderrLevels(levels []logrus.Level) StderrOption {
+	return func(hook *StderrHook) {
+		// An empty list is kept to be rejected by NewStderrHook.
+		hook.levels = append([]logrus.Level{}, levels...)
+	}
+}
+
+// WithStderrMinLevel makes the stderr hook print the level and all more severe ones.
+func WithStderrMinLevel(level logrus.Level) StderrOption {
+	return func(hook *StderrHook) {
+		// An unknown level is kept to be rejected by NewStderrHook.
+		hook.levels = []logrus.Level{level}
+		if checkLevels(hook.levels) == nil {
+			hook.levels = levelsUpTo(level)
+		}
+	}
+}
+
+// levelsUpTo returns the level and all more severe ones.
+func levelsUpTo(level logrus.Level) []logrus.Level {
+	var levels []logrus.Level
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+func checkLevels(levels []logrus.Level) error {
+	if len(levels) == 0 {
+		return errors.New("at least one level is required")
+	}
+	for _, level := range levels {
+		known := false
+		for _, l := range logrus.AllLevels {
+			if l == level {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown level %d", level)
+		}
+	}
+	return nil
+}
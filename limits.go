@@ -0,0 +1,146 @@
+package log_hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMessageTooLarge is returned when an email exceeds the size limits even without
+// the stack trace and the DATA section and with the message truncated. Such emails are dropped.
+var ErrMessageTooLarge = errors.New("email exceeds the size limit")
+
+// DefaultMaxMessageSize is the limit of the assembled email used when none is configured.
+const DefaultMaxMessageSize = 1 << 20
+
+// minTruncatedMessage is the length messages aren't truncated below to fit the size limit.
+const minTruncatedMessage = 64
+
+// WithMaxFieldSize truncates field values longer than n bytes, marking how much was cut.
+// Values that aren't strings are limited by the size of their JSON encoding.
+func WithMaxFieldSize(n int) MailOption {
+	return func(o *mailOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("max field size must be positive, got %d", n)
+		}
+		o.maxFieldSize = n
+		return nil
+	}
+}
+
+// WithMaxBodySize limits the rendered body to n bytes. Larger bodies are shrunk
+// like emails over the message size limit, see WithMaxMessageSize.
+func WithMaxBodySize(n int) MailOption {
+	return func(o *mailOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("max body size must be positive, got %d", n)
+		}
+		o.maxBodySize = n
+		return nil
+	}
+}
+
+// WithMaxMessageSize limits the assembled email to n bytes, DefaultMaxMessageSize by default.
+// Larger emails are sent without the stack trace, then without the DATA section,
+// then with the message truncated, with a note about what was removed.
+func WithMaxMessageSize(n int) MailOption {
+	return func(o *mailOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("max message size must be positive, got %d", n)
+		}
+		o.maxMessageSize = n
+		return nil
+	}
+}
+
+// truncateFields returns a copy of fields with the values over limit bytes truncated.
+func truncateFields(fields logrus.Fields, limit int) logrus.Fields {
+	if limit == 0 {
+		return fields
+	}
+
+	truncated := make(logrus.Fields, len(fields))
+	for key, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			if err, isErr := value.(error); isErr {
+				text = err.Error()
+			} else if encoded, err := json.Marshal(value); err == nil {
+				text = string(encoded)
+			}
+		}
+		if len(text) <= limit {
+			truncated[key] = value
+			continue
+		}
+		truncated[key] = truncateString(text, limit)
+	}
+	return truncated
+}
+
+// truncateString cuts s to at most limit bytes on a rune boundary and appends a marker.
+func truncateString(s string, limit int) string {
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", s[:cut], len(s)-cut)
+}
+
+// limitBody renders the body and checks it against the body size limit.
+func (m *mailer) limitBody(data BodyData, render func(BodyData) mimePart) (mimePart, error) {
+	part := render(data)
+	if limit := m.options.maxBodySize; limit > 0 && len(part.body) > limit {
+		return part, fmt.Errorf("%w: body of %d bytes over %d", ErrMessageTooLarge, len(part.body), limit)
+	}
+	return part, nil
+}
+
+// limitMessage builds the email, removing parts of it until it fits the size limits.
+func (m *mailer) limitMessage(data BodyData, build func(BodyData) (*bytes.Buffer, error)) (*bytes.Buffer, error) {
+	limit := m.options.maxMessageSize
+	if limit == 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	for {
+		message, err := build(data)
+		if err == nil && message.Len() <= limit {
+			return message, nil
+		}
+		if err != nil && !errors.Is(err, ErrMessageTooLarge) {
+			return nil, err
+		}
+		if !shrink(&data) {
+			if err == nil {
+				err = fmt.Errorf("%w: %d bytes over %d", ErrMessageTooLarge, message.Len(), limit)
+			}
+			return nil, err
+		}
+	}
+}
+
+// shrink removes the next part of the email: the history, the stack trace, the DATA section,
+// then half of the message. It returns false when there is nothing left to remove.
+func shrink(data *BodyData) bool {
+	switch {
+	case len(data.History) > 0:
+		data.Notes = append(data.Notes, fmt.Sprintf("%d history line(s) omitted to fit the size limit", len(data.History)))
+		data.History = nil
+	case data.Stack != "":
+		data.Stack = ""
+		data.Notes = append(data.Notes, "stack trace omitted to fit the size limit")
+	case len(data.Data) > 0:
+		data.Notes = append(data.Notes, fmt.Sprintf("%d field(s) omitted to fit the size limit", len(data.Data)))
+		data.Data = logrus.Fields{}
+	case len(data.Message) > minTruncatedMessage:
+		data.Message = truncateString(data.Message, len(data.Message)/2)
+	default:
+		return false
+	}
+	return true
+}
@@ -1,57 +1,288 @@
 package log_hooks
 
 import (
-	"bytes"
-	"encoding/json"
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/mail"
 	"net/smtp"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-var errStore = &mailErrStore{errToTime: make(map[string]time.Time), generalErr: "general"}
-
-type mailErrStore struct {
-	errToTime   map[string]time.Time
-	generalErr  string
+// DedupStore remembers when emails were sent. By default every recipient set may get one
+// email a minute, and the same error is sent once every 10 minutes to any of them, see
+// WithSuppressionWindows. Every hook has its own store unless one is shared with WithDedupStore.
+// Errors are forgotten once they can't suppress anything anymore, so the store doesn't grow
+// with the number of distinct messages.
+type DedupStore struct {
+	errToTime map[string]time.Time
+	// recipientsToTime is keyed by the sorted recipient set, see recipientsKey. It is the last
+	// send, moved ahead one refill for each send of a burst, see bucket.
+	recipientsToTime map[string]time.Time
+	// errToThread is the Message-ID of the first email sent for the error.
+	errToThread map[string]string
+	// errToSuppressed counts the entries suppressed since the error was last sent.
+	errToSuppressed map[string]int
+	// errToSeen is when a suppressed error last occurred.
+	errToSeen map[string]time.Time
+	// errToSends counts the emails sent for the error, see WithBackoffSuppression.
+	errToSends map[string]int
+	// errToWindow is the message window of the error and the emails sent in it, see WithMaxPerWindow.
+	errToWindow map[string]windowCount
+	// errToClaim is the send TryAcquire recorded, until MarkSent or Release.
+	errToClaim map[string]claim
+	// errToFirst is when the error first occurred since the store remembers it.
+	errToFirst  map[string]time.Time
 	errToTimeMu sync.RWMutex
+
+	// recent orders the tracked errors from the most recently seen one, see WithMaxTrackedErrors.
+	recent     *list.List
+	recentKeys map[string]*list.Element
+	maxTracked int
+	evicted    atomic.Int64
+	// expired counts the errors forgotten after their window, see sweep.
+	expired atomic.Int64
+	// hits and misses count the sends of errors the store knew and of new ones.
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// onEvict is called with the dropped errors, see WithOnEvict.
+	onEvict   func(key string, lastSeen time.Time)
+	droppedMu sync.Mutex
+	dropped   []droppedKey
+
+	// maxWindow is the largest suppression window the store was checked with, see sweep.
+	maxWindow atomic.Int64
+	lastSweep time.Time
+	sweptSize int
+
+	// path is the file of the store, see WithDedupFile.
+	path string
+
+	// now is the clock of the store, see WithClock.
+	now func() time.Time
+	// windowMode is where the message windows start, see WithWindowMode.
+	windowMode WindowMode
+}
+
+// NewDedupStore creates an empty store to share between hooks, see WithDedupStore.
+func NewDedupStore(opts ...DedupStoreOption) *DedupStore {
+	es := &DedupStore{now: time.Now}
+	es.reset()
+	for _, opt := range opts {
+		opt(es)
+	}
+	if es.path != "" {
+		es.load()
+	}
+	return es
+}
+
+// reset empties the store, the caller holds errToTimeMu unless the store is new.
+func (es *DedupStore) reset() {
+	es.errToTime = make(map[string]time.Time)
+	es.recipientsToTime = make(map[string]time.Time)
+	es.errToThread = make(map[string]string)
+	es.errToSuppressed = make(map[string]int)
+	es.errToSeen = make(map[string]time.Time)
+	es.errToSends = make(map[string]int)
+	es.errToFirst = make(map[string]time.Time)
+	es.errToWindow = make(map[string]windowCount)
+	es.errToClaim = make(map[string]claim)
+	es.recent = list.New()
+	es.recentKeys = make(map[string]*list.Element)
+}
+
+// SharedDedupStore is a package-level store for hooks that should suppress each other's
+// duplicates, pass it to WithDedupStore.
+//
+// Deprecated: it is shared with every other package using it, share a store from
+// NewDedupStore instead.
+var SharedDedupStore = NewDedupStore()
+
+// WithDedupStore makes the hook use store for deduplication and rate limiting, so hooks
+// sharing it don't send the same error twice.
+func WithDedupStore(store *DedupStore) MailOption {
+	return func(o *mailOptions) error {
+		if store == nil {
+			return errors.New("dedup store must not be nil")
+		}
+		o.dedupStore = store
+		return nil
+	}
+}
+
+// WithLevelSuppressionWindow sets the message window for entries of the level, e.g. 5 minutes
+// for panic and 30 for warn, instead of the one of WithSuppressionWindows.
+func WithLevelSuppressionWindow(level logrus.Level, window time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if _, err := level.MarshalText(); err != nil {
+			return err
+		}
+		if window < 0 {
+			return fmt.Errorf("suppression window must not be negative, got %s", window)
+		}
+		if o.levelWindows == nil {
+			o.levelWindows = make(map[logrus.Level]time.Duration)
+		}
+		o.levelWindows[level] = window
+		return nil
+	}
+}
+
+// WithRateLimitBypass sends entries of the levels, e.g. error, even when their
+// recipients already got an email within the recipient window. Duplicates are still suppressed.
+func WithRateLimitBypass(levels ...logrus.Level) MailOption {
+	return func(o *mailOptions) error {
+		for _, level := range levels {
+			if _, err := level.MarshalText(); err != nil {
+				return err
+			}
+		}
+		o.rateLimitBypass = append(o.rateLimitBypass, levels...)
+		return nil
+	}
+}
+
+// DefaultBypassLevels are the levels sent regardless of the suppression windows by default.
+var DefaultBypassLevels = []logrus.Level{logrus.PanicLevel, logrus.FatalLevel}
+
+// WithBypassLevels sends the entries of the levels, DefaultBypassLevels by default, regardless of
+// the recipient and message windows. Their emails are still recorded, so duplicates of a lower
+// level are suppressed after them. No levels make every entry subject to the windows.
+func WithBypassLevels(levels ...logrus.Level) MailOption {
+	return func(o *mailOptions) error {
+		for _, level := range levels {
+			if _, err := level.MarshalText(); err != nil {
+				return err
+			}
+		}
+		o.bypassLevels = append([]logrus.Level{}, levels...)
+		return nil
+	}
+}
+
+// DefaultRecipientWindow is how long a recipient set waits after an email by default.
+const DefaultRecipientWindow = time.Minute
+
+// DefaultMessageWindow is how long the same error is suppressed after it was sent by default.
+const DefaultMessageWindow = 10 * time.Minute
+
+// WithSuppressionWindows sets how long a recipient set waits after an email, and how long
+// the same error is suppressed after it was sent. Zero sends every email. The recipient
+// window is the refill of the token bucket of WithTokenBucket.
+func WithSuppressionWindows(recipientWindow time.Duration, messageWindow time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if recipientWindow < 0 || messageWindow < 0 {
+			return fmt.Errorf("suppression windows must not be negative, got %s and %s", recipientWindow, messageWindow)
+		}
+		o.recipientWindow = recipientWindow
+		o.messageWindow = messageWindow
+		o.windowsSet = true
+		return nil
+	}
 }
 
 // MailHook to sends logs by email without authentication.
 type MailHook struct {
-	appName   string
-	host      string
-	port      int
-	sender    string
-	recipient string
+	*mailer
 }
 
 // MailAuthHook to sends logs by email with authentication.
 type MailAuthHook struct {
-	appName   string
-	host      string
-	port      int
-	sender    string
-	recipient string
-	username  string
-	password  string
+	*mailer
+	username string
+	password string
+}
+
+// mailer holds the settings shared by the mail hooks.
+type mailer struct {
+	appName    string
+	host       string
+	port       int
+	from       *mail.Address
+	recipients []string
+	addresses  addressBook
+	hostname   string
+	pid        int
+	goVersion  string
+	build      string
+	options    mailOptions
+
+	// endpoints are the servers to send through, the hook host first, see WithFallback.
+	endpoints []*endpoint
+	// transport delivers the emails, smtpSender unless set by WithSender or NewMailHookSendmail.
+	transport Sender
+	// preferred is the index of the endpoint that worked last.
+	preferred int32
+
+	// queue is used for sending in the background, see WithAsync.
+	queue *sendQueue
+
+	// digest batches entries, see WithDigest.
+	digest *digest
+
+	// summaries counts suppressed errors until their window elapses, see WithSuppressionSummary.
+	summaries *summarizer
+
+	dryRunLog dryRunLog
+
+	stats stats
+
+	// errors calls the error handler, see WithOnError.
+	errors errorReporter
+
+	closed atomic.Bool
+
+	// spool keeps undelivered emails, see WithSpool.
+	spool *spool
+
+	// store deduplicates and rate limits the emails, see WithDedupStore.
+	store *DedupStore
+	// storePause is when to ask the suppression store again after it failed, in Unix
+	// nanoseconds, see WithSuppressionStore.
+	storePause atomic.Int64
+
+	// historySeq is the latest history record sent, guarded by the history, see WithHistory.
+	historySeq uint64
 }
 
 type StderrHook struct {
 	textFormater *logrus.TextFormatter
+	errors       errorReporter
+	stack        stackPolicy
+	levels       []logrus.Level
+}
+
+// StderrOption configures optional behaviour of StderrHook.
+type StderrOption func(*StderrHook)
+
+// WithStderrOnError calls fn whenever the stderr hook fails, see WithOnError.
+func WithStderrOnError(fn ErrorHandler) StderrOption {
+	return func(hook *StderrHook) {
+		hook.errors.handler = fn
+	}
 }
 
 // 1) set output format to stdout [text|json]
 // 2) set verbosity [panic|fatal|error|warn|info|debug|trace]
 // 3) sending errors to emails [panic|fatal|error|warn]
-// 4) sending logs to stdout [info|debug|trace|panic|fatal|error|warn] and errors to stderr [panic|fatal|error|warn]
+// 4) sending logs to stdout [info|debug|trace|panic|fatal|error|warn] and errors to stderr [panic|fatal|error|warn], see WithStderrLevel
+// The returned mail hook should be closed before exiting so pending emails are sent. log.Fatal
+// doesn't run deferred calls, so the hook is also closed by a logrus exit handler.
 func UsefulSetupLogrus(
 	log *logrus.Logger,
 	mailHostPort string,
@@ -60,36 +291,82 @@ func UsefulSetupLogrus(
 	appName string,
 	sender string,
 	recipient string,
-) error {
+	opts ...SetupOption,
+) (io.Closer, error) {
+	var setup setupOptions
+	for _, opt := range opts {
+		opt(&setup)
+	}
+
 	log.Out = os.Stdout
 
 	host, strPort, err := net.SplitHostPort(mailHostPort)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	port, err := strconv.Atoi(strPort)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	logLevel, err := logrus.ParseLevel(level)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.SetLevel(logLevel)
 
-	stderrHook, err := NewStderrHook()
+	var stderrOptions []StderrOption
+	if setup.stderrLevel != "" {
+		stderrLevel, err := logrus.ParseLevel(setup.stderrLevel)
+		if err != nil {
+			return nil, err
+		}
+		stderrOptions = append(stderrOptions, WithStderrMinLevel(stderrLevel))
+	}
+	stderrHook, err := NewStderrHook(stderrOptions...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Hooks.Add(stderrHook)
 
-	mailHook, err := NewMailHook(appName, host, port, sender, recipient)
-	if err != nil {
-		return err
+	mailOptions := setup.mailOptions
+	if setup.mailLevel != "" {
+		mailLevel, err := logrus.ParseLevel(setup.mailLevel)
+		if err != nil {
+			return nil, err
+		}
+		mailOptions = append(mailOptions, WithMinLevel(mailLevel))
+	}
+	if setup.dedupStore != nil {
+		mailOptions = append(mailOptions, WithDedupStore(setup.dedupStore))
+	}
+	if setup.dedupField != "" {
+		mailOptions = append(mailOptions, WithDedupField(setup.dedupField))
+	}
+	if setup.noDedup {
+		mailOptions = append(mailOptions, WithoutDedup())
+	}
+
+	var closer io.Closer
+	if setup.username != "" {
+		mailHook, err := NewMailAuthHook(appName, host, port, sender, recipient, setup.username, setup.password, mailOptions...)
+		if err != nil {
+			return nil, err
+		}
+		log.Hooks.Add(mailHook)
+		closer = mailHook
+	} else {
+		mailHook, err := NewMailHook(appName, host, port, sender, recipient, mailOptions...)
+		if err != nil {
+			return nil, err
+		}
+		log.Hooks.Add(mailHook)
+		closer = mailHook
 	}
-	log.Hooks.Add(mailHook)
+
+	// Close errors can't be reported anymore, the logger is exiting.
+	logrus.RegisterExitHandler(func() { _ = closer.Close() })
 
 	if format == "json" {
 		log.SetFormatter(&logrus.JSONFormatter{})
@@ -98,170 +375,551 @@ func UsefulSetupLogrus(
 		customFormatter.FullTimestamp = true
 		log.SetFormatter(customFormatter)
 	}
-	return nil
+	return closer, nil
 }
 
 // NewMailHook creates a hook to be added to an instance of logger.
-func NewMailHook(appname string, host string, port int, sender string, recipient string) (*MailHook, error) {
-	err := checkMailHookParams(host, port, sender, recipient)
+func NewMailHook(appname string, host string, port int, sender string, recipient string, opts ...MailOption) (*MailHook, error) {
+	return NewMailHookMulti(appname, host, port, sender, []string{recipient}, opts...)
+}
+
+// NewMailHookMulti creates a hook sending each email to all recipients.
+func NewMailHookMulti(appname string, host string, port int, sender string, recipients []string, opts ...MailOption) (*MailHook, error) {
+	m, err := newMailer(appname, host, port, sender, recipients, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &MailHook{
-		appName:   appname,
-		host:      host,
-		port:      port,
-		sender:    sender,
-		recipient: recipient,
-	}, nil
+	if err := m.checkInsecureAuth(); err != nil {
+		return nil, err
+	}
+
+	return &MailHook{mailer: m}, nil
 }
 
 // NewMailAuthHook creates a hook to be added to an instance of logger.
-//func NewMailAuthHook(appName string, host string, port int, sender string, recipient string, username string, password string) (*MailAuthHook, error) {
-//	err := checkMailHookParams(host, port, sender, recipient)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	return &MailAuthHook{
-//		appName:   appName,
-//		host:      host,
-//		port:      port,
-//		sender:    sender,
-//		recipient: recipient,
-//		username:  username,
-//		password:  password,
-//	}, nil
-//}
+func NewMailAuthHook(appName string, host string, port int, sender string, recipient string, username string, password string, opts ...MailOption) (*MailAuthHook, error) {
+	return NewMailAuthHookMulti(appName, host, port, sender, []string{recipient}, username, password, opts...)
+}
+
+// NewMailAuthHookMulti creates an authenticated hook sending each email to all recipients.
+func NewMailAuthHookMulti(appName string, host string, port int, sender string, recipients []string, username string, password string, opts ...MailOption) (*MailAuthHook, error) {
+	m, err := newMailer(appName, host, port, sender, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCredentials(username, password, m.options); err != nil {
+		return nil, err
+	}
+
+	// A sender set by WithSender has no endpoints.
+	if len(m.endpoints) > 0 {
+		m.endpoints[0].username = username
+		m.endpoints[0].password = password
+	}
+
+	if err := m.checkInsecureAuth(); err != nil {
+		return nil, err
+	}
+
+	return &MailAuthHook{
+		mailer:   m,
+		username: username,
+		password: password,
+	}, nil
+}
+
+func newMailer(appName string, host string, port int, sender string, recipients []string, opts []MailOption) (*mailer, error) {
+	options, err := newMailOptions(host, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.skipConnectivityCheck || options.transport != nil {
+		err = checkAddressParams(sender, recipients)
+	} else {
+		err = checkMailHookParams(options, host, port, sender, recipients)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buildMailer(appName, host, port, sender, recipients, options, nil), nil
+}
+
+// buildMailer creates a mailer from validated settings. Without a transport, from the
+// options or the caller, it sends through the host and the fallbacks.
+func buildMailer(appName string, host string, port int, sender string, recipients []string, options mailOptions, t Sender) *mailer {
+	if options.transport != nil {
+		t = options.transport
+	}
+	if appName == "" {
+		appName = filepath.Base(os.Args[0])
+	}
+	hostname, _ := os.Hostname()
+
+	m := &mailer{
+		appName:    appName,
+		host:       host,
+		port:       port,
+		from:       parseAddress(sender),
+		recipients: append([]string(nil), recipients...),
+		hostname:   hostname,
+		pid:        os.Getpid(),
+		goVersion:  runtime.Version(),
+		build:      buildDescription(),
+		options:    options,
+		transport:  t,
+	}
+	static := [][]string{m.recipients, options.cc, options.bcc, options.replyTo}
+	for _, recipients := range options.levelRecipients {
+		static = append(static, recipients)
+	}
+	m.addresses = newAddressBook(static...)
+	m.errors.handler = options.onError
+	m.store = options.dedupStore
+	if m.store == nil {
+		m.store = NewDedupStore()
+	}
+	if t == nil {
+		m.endpoints = append(m.endpoints, newEndpoint(Endpoint{Host: host, Port: port}, options))
+		for _, fallback := range options.fallbacks {
+			m.endpoints = append(m.endpoints, newEndpoint(fallback, options))
+		}
+		m.transport = smtpSender{m}
+	}
+	if options.async {
+		// Queued emails outlive the entry, so they aren't bound by its context.
+		m.queue = newSendQueue(options.queueSize, options.queuePolicy, options.queueBlockTimeout, func(out outgoing) error {
+			err := m.deliver(context.Background(), out)
+			if err != nil && !out.quiet {
+				m.reportError(out.entry, err)
+			}
+			return err
+		}, func(out outgoing) {
+			// Only the error handler hears of drops, stderr would be flooded in an error storm.
+			if !out.quiet {
+				m.errors.handle(out.entry, ErrQueueFull)
+			}
+		})
+	}
+	if options.digestWindow > 0 {
+		m.digest = newDigest(options.digestWindow, m.digestMessage, m.submitTo, func(err error) {
+			m.reportError(nil, err)
+		})
+	}
+	if options.summary {
+		m.summaries = newSummarizer(m.summaryMessage, m.submitTo, func(err error) {
+			m.reportError(nil, err)
+		})
+	}
+	if options.spoolDir != "" {
+		m.spool = newSpool(options)
+		go m.spool.run(options.spoolInterval, m.redeliver, func(err error) {
+			m.reportError(nil, err)
+		})
+	}
+	if options.expvarName != "" {
+		m.publishStats(options.expvarName)
+	}
+	return m
+}
 
 // NewStderrHook creates a hook for moving errors to stderr
-func NewStderrHook() (*StderrHook, error) {
-	return &StderrHook{
+func NewStderrHook(opts ...StderrOption) (*StderrHook, error) {
+	hook := &StderrHook{
 		textFormater: new(logrus.TextFormatter),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(hook)
+	}
+	if hook.levels != nil {
+		if err := checkLevels(hook.levels); err != nil {
+			return nil, err
+		}
+	}
+	return hook, nil
 }
 
-func (es *mailErrStore) saveErrorTime(error string) {
-	es.errToTimeMu.Lock()
-	defer es.errToTimeMu.Unlock()
-	es.errToTime[error] = time.Now()
+// reservation is a send recorded by tryAcquire, see markSent and release.
+type reservation struct {
+	recipients string
+	key        string
+	at         time.Time
+	// slot is the time of the recipient set after the send, see bucket.take.
+	slot time.Time
+	// prevRecipients and prevErr are the times the reservation replaced, zero if none.
+	prevRecipients time.Time
+	prevErr        time.Time
+	// streak is the number of emails sent for the error before, see WithBackoffSuppression.
+	streak int
+	// prevWindow is the message window of the error before the send, see WithMaxPerWindow.
+	prevWindow windowCount
 }
 
-func (es *mailErrStore) markErrAsSent(entry *logrus.Entry) {
-	es.saveErrorTime(es.generalErr)
-	es.saveErrorTime(entry.Message)
+// suppression tells why and until when an entry is suppressed.
+type suppression struct {
+	reason SuppressReason
+	until  time.Time
 }
 
-func (es *mailErrStore) checkErrorTime(error string, duration time.Duration) bool {
-	es.errToTimeMu.RLock()
-	defer es.errToTimeMu.RUnlock()
-	if errTime, ok := es.errToTime[error]; ok {
-		if errTime.Add(duration).After(time.Now()) {
-			return false
+// tryAcquire checks the bucket of the recipients and the window of the error, which lets
+// perWindow emails through, and records the send under one lock, so of the entries with the
+// same key logged at once no more than allowed are sent.
+func (es *DedupStore) tryAcquire(recipients string, key string, limit bucket, messageWindow time.Duration, perWindow int, coolOff time.Duration) (reservation, suppression, bool) {
+	es.observeWindow(max(limit.every, messageWindow))
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	now := es.now()
+	if until := limit.blockedUntil(es.recipientsToTime[recipients], now); !until.IsZero() {
+		return reservation{}, suppression{reason: SuppressRateLimit, until: until}, false
+	}
+	if start, ok := es.windowStart(key); ok && start.Add(messageWindow).After(now) {
+		// Errors known from before the counts, see dedupErr, used up their window.
+		if window, counted := es.errToWindow[key]; !counted || window.sends >= perWindow {
+			return reservation{}, suppression{reason: SuppressDuplicate, until: start.Add(messageWindow)}, false
 		}
+	} else {
+		delete(es.errToWindow, key)
 	}
+	return es.reserve(recipients, key, limit, coolOff, now), suppression{}, true
+}
 
-	return true
+// reserve records a send at now. The caller holds errToTimeMu.
+func (es *DedupStore) reserve(recipients string, key string, limit bucket, coolOff time.Duration, now time.Time) reservation {
+	if _, ok := es.recentKeys[key]; ok {
+		es.hits.Add(1)
+	} else {
+		es.misses.Add(1)
+	}
+	res := reservation{
+		recipients:     recipients,
+		key:            key,
+		at:             now,
+		slot:           limit.take(es.recipientsToTime[recipients], now),
+		prevRecipients: es.recipientsToTime[recipients],
+		prevErr:        es.errToTime[key],
+		// The streak of sends starts over after the cool-off, see WithBackoffSuppression.
+		streak:     es.streakLocked(key, coolOff, now),
+		prevWindow: es.errToWindow[key],
+	}
+	window := res.prevWindow
+	if window.sends == 0 {
+		window.start = now
+	}
+	window.sends++
+	es.recipientsToTime[recipients] = res.slot
+	es.errToTime[key] = now
+	es.errToWindow[key] = window
+	es.touch(key, now)
+	es.sweep(now)
+	return res
 }
 
-func (es *mailErrStore) canSendMail(entry *logrus.Entry) bool {
-	if !es.checkErrorTime(es.generalErr, time.Minute) {
-		return false
+// markSent records the delivery of the reserved email, its windows start now. reported is
+// the number of suppressed entries the email told about, later suppressions are kept for
+// the next one.
+func (es *DedupStore) markSent(res reservation, messageID string, reported int) {
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	now := es.now()
+	if es.recipientsToTime[res.recipients].Equal(res.slot) {
+		es.recipientsToTime[res.recipients] = res.slot.Add(now.Sub(res.at))
+	}
+	es.errToTime[res.key] = now
+	es.touch(res.key, now)
+	// The first email starts the window and counts for the backoff, the rest are its burst.
+	if res.prevWindow.sends == 0 {
+		if window, ok := es.errToWindow[res.key]; ok && window.start.Equal(res.at) {
+			window.start = now
+			es.errToWindow[res.key] = window
+		}
+		es.errToSends[res.key] = res.streak + 1
+	}
+	if _, ok := es.errToThread[res.key]; !ok {
+		es.errToThread[res.key] = messageID
+	}
+	if es.errToSuppressed[res.key] -= reported; es.errToSuppressed[res.key] <= 0 {
+		delete(es.errToSuppressed, res.key)
 	}
+}
 
-	if !es.checkErrorTime(entry.Message, 10*time.Minute) {
-		return false
+// release undoes a reservation whose email wasn't sent, unless it was replaced since.
+func (es *DedupStore) release(res reservation) {
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	restore := func(times map[string]time.Time, key string, reserved time.Time, prev time.Time) {
+		if current, ok := times[key]; !ok || !current.Equal(reserved) {
+			return
+		}
+		if prev.IsZero() {
+			delete(times, key)
+		} else {
+			times[key] = prev
+		}
+	}
+	restore(es.recipientsToTime, res.recipients, res.slot, res.prevRecipients)
+	if current, ok := es.errToTime[res.key]; ok && current.Equal(res.at) {
+		if res.prevWindow.sends == 0 {
+			delete(es.errToWindow, res.key)
+		} else {
+			es.errToWindow[res.key] = res.prevWindow
+		}
 	}
+	restore(es.errToTime, res.key, res.at, res.prevErr)
+}
 
-	return true
+// suppress counts an entry that wasn't sent.
+func (es *DedupStore) suppress(key string) {
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	es.errToSuppressed[key]++
+	now := es.now()
+	es.errToSeen[key] = now
+	es.touch(key, now)
+	es.sweep(now)
+}
+
+// suppressed returns how many entries were suppressed since the error was last sent, and when.
+func (es *DedupStore) suppressed(key string) (int, time.Time) {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	return es.errToSuppressed[key], es.errToTime[key]
+}
+
+// windowSends returns how many emails were sent for the error in its message window.
+func (es *DedupStore) windowSends(key string) int {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	return es.errToWindow[key].sends
+}
+
+// thread returns the Message-ID of the first email sent for the error, if any.
+func (es *DedupStore) thread(key string) string {
+	es.errToTimeMu.RLock()
+	defer es.errToTimeMu.RUnlock()
+	return es.errToThread[key]
 }
 
 // Fire is called when a log event is fired.
 func (hook *MailHook) Fire(entry *logrus.Entry) error {
+	return hook.fire(entry)
+}
 
-	// Connect to the remote SMTP server.
-	client, err := smtp.Dial(hook.host + ":" + strconv.Itoa(hook.port))
+// Fire is called when a log event is fired.
+func (hook *MailAuthHook) Fire(entry *logrus.Entry) error {
+	return hook.fire(entry)
+}
+
+// fire sends the entry and passes failures to the error handler.
+func (m *mailer) fire(entry *logrus.Entry) error {
+	err := m.sendEntry(entry)
 	if err != nil {
-		return err
+		// logrus prints the returned error itself, so there is no stderr fallback.
+		m.errors.handle(entry, err)
 	}
+	return err
+}
 
-	defer func() { _ = client.Close() }()
-
-	if !errStore.canSendMail(entry) {
+// sendEntry sends the entry unless it is suppressed. The error is marked as sent
+// only once the email has been delivered. A synchronous send is abandoned when
+// the context of the entry is done.
+func (m *mailer) sendEntry(entry *logrus.Entry) error {
+	if m.closed.Load() {
+		return ErrClosed
+	}
+	if m.digest != nil && !bypassesDigest(entry) {
+		m.digest.add(m.redactMessage(entry.Message), m.recipientsFor(entry), func() BodyData {
+			return m.bodyData(entry)
+		})
 		return nil
 	}
 
-	if err := client.Mail(hook.sender); err != nil {
-		return err
+	recipients := m.recipientsFor(entry)
+	throttle := recipientsKey(recipients)
+	key := m.routedKey(m.dedupKey(entry), throttle)
+	window, nextWindow := m.messageWindows(key, entry.Level)
+	recipientWindow := m.options.recipientWindow
+	if slices.Contains(m.options.rateLimitBypass, entry.Level) {
+		recipientWindow = 0
+	}
+	if slices.Contains(m.options.bypassLevels, entry.Level) {
+		recipientWindow, window = 0, 0
+	}
+	// An empty key bypasses the store, see KeyFunc.
+	var res reservation
+	if key != "" {
+		var blocked suppression
+		var ok bool
+		limit := bucket{every: recipientWindow, burst: m.options.burst}
+		// A SuppressionStore decides on the duplicates, the dedup store only rate limits.
+		storeWindow := window
+		if m.options.suppressionStore != nil {
+			storeWindow = 0
+		}
+		if res, blocked, ok = m.store.tryAcquire(throttle, key, limit, storeWindow, m.options.maxPerWindow, m.options.backoffCoolOff); !ok {
+			m.suppress(entry, key, recipients, blocked)
+			return nil
+		}
+	}
+	external := key != "" && m.options.suppressionStore != nil
+	var claimed bool
+	if external {
+		var ok bool
+		if ok, claimed = m.storeAcquire(entry, key, window); !ok {
+			m.store.release(res)
+			m.suppress(entry, key, recipients, suppression{reason: SuppressStore, until: time.Now().Add(window)})
+			return nil
+		}
 	}
 
-	if err := client.Rcpt(hook.recipient); err != nil {
-		return err
+	header := headerData{
+		recipients: recipients,
+		messageID:  newMessageID(m.hostname),
 	}
-	wc, err := client.Data()
+	if key != "" && m.options.backoffMax > 0 {
+		header.suppressFor = nextWindow
+	}
+	var suppressed int
+	if key != "" {
+		header.inReplyTo = m.store.thread(key)
+		suppressed, _ = m.store.suppressed(key)
+	}
+	message, err := m.createMessage(entry, header, suppressed, res.prevErr)
 	if err != nil {
+		if key != "" {
+			m.store.release(res)
+		}
+		if claimed {
+			m.storeRelease(key)
+		}
 		return err
 	}
-	defer func() { _ = wc.Close() }()
 
-	errStore.markErrAsSent(entry)
-
-	message := createMessage(entry, hook.appName)
-	if _, err = message.WriteTo(wc); err != nil {
-		return err
+	out := outgoing{
+		entry:      entry,
+		message:    message.Bytes(),
+		recipients: recipients,
 	}
-	return nil
-}
-
-// Fire is called when a log event is fired.
-func (hook *MailAuthHook) Fire(entry *logrus.Entry) error {
-
-	if !errStore.canSendMail(entry) {
+	if key != "" {
+		out.sent = func() {
+			m.store.markSent(res, header.messageID, suppressed)
+			if external {
+				m.storeMarkSent(key, nextWindow)
+			}
+		}
+		out.failed = func() {
+			m.store.release(res)
+			if claimed {
+				m.storeRelease(key)
+			}
+		}
+	}
+	// logrus exits right after the hooks of a Fatal entry without closing the hook, and a
+	// panic may end the program, so these are sent before Fire returns even when async.
+	if m.queue != nil && entry.Level > logrus.FatalLevel {
+		out.entry = copyEntry(entry)
+		// Entries logged by the error handler are not reported to it again.
+		out.quiet = m.errors.running()
+		m.queue.push(out)
 		return nil
 	}
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err = m.deliver(ctx, out)
+	if m.queue != nil && entry.Level == logrus.FatalLevel {
+		// The queued emails would be lost on exit too.
+		flushCtx, cancel := context.WithTimeout(context.Background(), m.options.closeTimeout)
+		defer cancel()
+		if flushErr := m.queue.flush(flushCtx); flushErr != nil {
+			m.reportError(entry, fmt.Errorf("queued emails not sent before exit: %w", flushErr))
+		}
+	}
+	return err
+}
 
-	auth := smtp.PlainAuth("", hook.username, hook.password, hook.host)
+// sendTo delivers a rendered message through the endpoint to all envelope recipients.
+// Cancelling ctx abandons the conversation.
+func (m *mailer) sendTo(ctx context.Context, ep *endpoint, from string, to []string, message []byte) (err error) {
+	client, err := m.acquire(ctx, ep)
+	if err != nil {
+		m.countConnectError(err)
+		return err
+	}
 
-	message := createMessage(entry, hook.appName)
+	client.trace.reset()
+	stop := context.AfterFunc(ctx, func() { _ = client.conn.SetDeadline(time.Now()) })
+	defer func() {
+		stop()
+		err = client.trace.attach(err)
+		m.release(ep, client, err)
+		var partial *partialDeliveryError
+		if err != nil && !errors.As(err, &partial) {
+			m.stats.dataErrors.Add(1)
+		}
+		err = timeoutError(err)
+	}()
 
-	errStore.markErrAsSent(entry)
+	if err := client.Mail(from); err != nil {
+		return err
+	}
 
-	// Connect to the server, authenticate, set the sender and recipient,
-	// and send the email all in one step.
-	err := smtp.SendMail(
-		hook.host+":"+strconv.Itoa(hook.port),
-		auth,
-		hook.sender,
-		[]string{hook.recipient},
-		message.Bytes(),
-	)
+	rejected, ok := rcptAll(client, to)
+	if !ok {
+		return rejected
+	}
+	wc, err := client.Data()
 	if err != nil {
 		return err
 	}
+
+	if _, err = wc.Write(message); err != nil {
+		_ = wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	if rejected != nil {
+		return &partialDeliveryError{err: rejected}
+	}
 	return nil
 }
 
 func (hook *StderrHook) Fire(entry *logrus.Entry) (err error) {
 	line, err := hook.textFormater.Format(entry)
 	if err == nil {
-		_, _ = fmt.Fprintf(os.Stderr, string(line) + string(debug.Stack()))
+		if hook.stack.includes(entry.Level, logrus.TraceLevel) {
+			line = append(line, debug.Stack()...)
+		}
+		_, err = os.Stderr.Write(line)
+	}
+	if err != nil {
+		hook.errors.handle(entry, err)
 	}
 	return
 }
 
 // Levels returns the available logging levels.
 func (hook *MailAuthHook) Levels() []logrus.Level {
-	return []logrus.Level{
-		logrus.WarnLevel,
-		logrus.PanicLevel,
-		logrus.FatalLevel,
-		logrus.ErrorLevel,
-	}
+	return hook.levels()
 }
 
 // Levels returns the available logging levels.
 func (hook *MailHook) Levels() []logrus.Level {
+	return hook.levels()
+}
+
+func (m *mailer) levels() []logrus.Level {
+	if m.options.levels != nil {
+		return m.options.levels
+	}
 	return []logrus.Level{
 		logrus.WarnLevel,
 		logrus.PanicLevel,
@@ -270,7 +928,11 @@ func (hook *MailHook) Levels() []logrus.Level {
 	}
 }
 
+// Levels returns the levels printed to stderr, see WithStderrLevels.
 func (hook *StderrHook) Levels() []logrus.Level {
+	if hook.levels != nil {
+		return hook.levels
+	}
 	return []logrus.Level{
 		logrus.WarnLevel,
 		logrus.PanicLevel,
@@ -279,35 +941,86 @@ func (hook *StderrHook) Levels() []logrus.Level {
 	}
 }
 
-func createMessage(entry *logrus.Entry, appname string) *bytes.Buffer {
-	subject := appname + " - " + entry.Level.String()
-	data, _ := json.MarshalIndent(entry.Data, "", "\t")
-	body := "TIME: " + entry.Time.Format("2006-01-02 15:04:05-0700") + "\n" +
-		"MESSAGE: " + entry.Message + "\n\n" +
-		"DATA: " + string(data) + "\n\n" +
-		"STACKTRACE: \n" + string(debug.Stack());
+// reportError reports a failure that doesn't fail Fire, see WithOnError.
+func (m *mailer) reportError(entry *logrus.Entry, err error) {
+	m.errors.report(entry, err)
+}
+
+// envelopeRecipients returns all RCPT TO addresses without display names: recipients, cc and bcc.
+func (m *mailer) envelopeRecipients(recipients []string) []string {
+	all := make([]string, 0, len(recipients)+len(m.options.cc)+len(m.options.bcc))
+	all = append(all, recipients...)
+	all = append(all, m.options.cc...)
+	return m.addresses.envelope(append(all, m.options.bcc...))
+}
 
-	return bytes.NewBufferString(fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body))
+// partialDeliveryError means the email was sent, but some recipients were rejected.
+type partialDeliveryError struct {
+	err error
 }
 
-func checkMailHookParams(host string, port int, sender string, recipient string) error {
+func (e *partialDeliveryError) Error() string { return e.err.Error() }
+
+func (e *partialDeliveryError) Unwrap() error { return e.err }
+
+// rcptAll issues RCPT TO for every recipient. ok is false when none of them was accepted,
+// rejected joins the errors of the refused recipients.
+func rcptAll(client *session, recipients []string) (rejected error, ok bool) {
+	var errs []error
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			errs = append(errs, fmt.Errorf("recipient %q rejected: %w", recipient, err))
+			continue
+		}
+		ok = true
+	}
+	return errors.Join(errs...), ok
+}
+
+func checkMailHookParams(options mailOptions, host string, port int, sender string, recipients []string) error {
+	if len(recipients) == 0 {
+		return errors.New("at least one recipient is required")
+	}
 
 	// Check if server listens on that port.
-	conn, err := net.DialTimeout("tcp", host+":"+strconv.Itoa(port), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := options.dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		return err
 	}
 
 	defer func() { _ = conn.Close() }()
 
+	// End the session with QUIT, so relays don't log a dropped connection. With implicit
+	// TLS there is no plaintext session to end.
+	if !options.implicitTLS {
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		if client, err := smtp.NewClient(conn, host); err == nil && client.Hello(options.localName) == nil {
+			_ = client.Quit()
+		}
+	}
+
+	return checkAddressParams(sender, recipients)
+}
+
+// checkAddressParams validates the sender and the recipients.
+func checkAddressParams(sender string, recipients []string) error {
+	if len(recipients) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+
 	// Validate sender and recipient
-	_, err = mail.ParseAddress(sender)
+	_, err := mail.ParseAddress(sender)
 	if err != nil {
 		return err
 	}
-	_, err = mail.ParseAddress(recipient)
-	if err != nil {
-		return err
+	for _, recipient := range recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,219 @@
+package log_hooks
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFailedSendNotMarkedAsSent(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	mail, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := newAuthHook(t, server, "alerts", "secret")
+
+	for name, hook := range map[string]logrus.Hook{"MailHook": mail, "MailAuthHook": auth} {
+		server.rejectData.Store(1)
+		entry := testEntry(logrus.ErrorLevel, "flaky relay "+name)
+		if err := hook.Fire(entry); err == nil {
+			t.Fatalf("%s: rejected send succeeded", name)
+		}
+		// Neither the error nor the recipients are suppressed by the failed send.
+		before := len(server.received())
+		if err := hook.Fire(entry); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := len(server.received()) - before; n != 1 {
+			t.Fatalf("%s: got %d messages, want 1", name, n)
+		}
+		// Once sent, it is.
+		if err := hook.Fire(entry); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := len(server.received()) - before; n != 1 {
+			t.Fatalf("%s: got %d messages, want the duplicate suppressed", name, n)
+		}
+	}
+}
+
+func TestSuppressedEntriesDontConnect(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connectivity check ends its session with QUIT too.
+	if n := server.connections.Load(); n != 1 {
+		t.Fatalf("got %d connections after the check, want 1", n)
+	}
+
+	entry := testEntry(logrus.ErrorLevel, "burst")
+	for i := 0; i < 5; i++ {
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.connections.Load(); n != 2 {
+		t.Fatalf("got %d connections, want 1 for the check and 1 for the send", n)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	quits := 0
+	for _, command := range server.commandLog() {
+		if command == "QUIT" {
+			quits++
+		}
+	}
+	if quits != 2 {
+		t.Fatalf("got %d QUIT commands, want 2: %q", quits, server.commandLog())
+	}
+}
+
+func TestHooksHaveIndependentStores(t *testing.T) {
+	newHook := func(recipient string, opts ...MailOption) *MailHook {
+		opts = append([]MailOption{WithoutConnectivityCheck(), WithDryRun(nil)}, opts...)
+		hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", recipient, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hook
+	}
+	fireAll := func(hooks ...*MailHook) {
+		for _, hook := range hooks {
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, "same error")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	teamA, teamB := newHook("a@example.com"), newHook("b@example.com")
+	fireAll(teamA, teamB)
+	if a, b := len(teamA.DryRunMessages()), len(teamB.DryRunMessages()); a != 1 || b != 1 {
+		t.Fatalf("got %d and %d messages, want one each", a, b)
+	}
+
+	// A store shared on purpose suppresses the duplicate of the other hook.
+	store := NewDedupStore()
+	sharedA, sharedB := newHook("a@example.com", WithDedupStore(store)), newHook("b@example.com", WithDedupStore(store))
+	fireAll(sharedA, sharedB)
+	if a, b := len(sharedA.DryRunMessages()), len(sharedB.DryRunMessages()); a != 1 || b != 0 {
+		t.Fatalf("got %d and %d messages, want the second suppressed", a, b)
+	}
+}
+
+func TestUsefulSetupLogrusDedupStore(t *testing.T) {
+	server := newTestServer(t, nil)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port()))
+	storeOf := func(opts ...SetupOption) *DedupStore {
+		closer, err := UsefulSetupLogrus(logrus.New(), addr, "text", "info", "app", "alerts@example.com", "ops@example.com", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = closer.Close() })
+		return closer.(*MailHook).store
+	}
+
+	if storeOf() == storeOf() {
+		t.Fatal("setups share a dedup store")
+	}
+	shared := NewDedupStore()
+	if storeOf(WithMailDedupStore(shared)) != shared || storeOf(WithMailDedupStore(shared)) != shared {
+		t.Fatal("setups don't use the shared dedup store")
+	}
+}
+
+func TestTryAcquireConcurrent(t *testing.T) {
+	store := NewDedupStore()
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if res, _, ok := store.tryAcquire("ops@example.com", "same error", bucket{every: time.Minute, burst: 1}, 10*time.Minute, 1, 0); ok {
+				acquired.Add(1)
+				store.markSent(res, "<id>", 0)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n := acquired.Load(); n != 1 {
+		t.Fatalf("acquired %d times, want 1", n)
+	}
+}
+
+func TestConcurrentFireSendsOnce(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", WithoutConnectivityCheck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, "same error")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	if st := hook.Stats(); st.Sent != 1 || st.Suppressed != 99 {
+		t.Fatalf("got %+v, want 1 sent and 99 suppressed", st)
+	}
+}
+
+func TestBypassLevels(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))))
+
+	if n := countSent(t, hook, testEntry(logrus.WarnLevel, "database down")); n != 1 {
+		t.Fatal("warning suppressed")
+	}
+	// Within both windows, the fatal entry is sent anyway.
+	clock.Advance(20 * time.Second)
+	if n := countSent(t, hook, testEntry(logrus.FatalLevel, "database down")); n != 1 {
+		t.Fatal("fatal entry suppressed")
+	}
+	if n := countSent(t, hook, testEntry(logrus.PanicLevel, "database down")); n != 1 {
+		t.Fatal("panic entry suppressed")
+	}
+	// It is still recorded, lower level duplicates stay suppressed.
+	clock.Advance(time.Minute)
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "database down")); n != 0 {
+		t.Fatal("duplicate after the fatal entry sent")
+	}
+}
+
+func TestBypassLevelsOption(t *testing.T) {
+	hook := newDryRunHook(t, WithBypassLevels(logrus.PanicLevel))
+	countSent(t, hook, testEntry(logrus.WarnLevel, "database down"))
+	if n := countSent(t, hook, testEntry(logrus.FatalLevel, "database down")); n != 0 {
+		t.Fatal("fatal entry bypassed the limits")
+	}
+	if n := countSent(t, hook, testEntry(logrus.PanicLevel, "database down")); n != 1 {
+		t.Fatal("panic entry suppressed")
+	}
+}
@@ -0,0 +1,118 @@
+package log_hooks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// MemcacheStore is a SuppressionStore on memcached. TryAcquire claims the key with ADD and the
+// window as expiry, rounded up to whole seconds, so it expires by itself, Release deletes it.
+// Services sharing a server need their own Prefix.
+type MemcacheStore struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Prefix is prepended to the keys, "log_hooks:" when empty.
+	Prefix string
+	// TLSConfig enables TLS.
+	TLSConfig *tls.Config
+	// Dialer opens the connection, a net.Dialer when nil.
+	Dialer ContextDialer
+
+	conn storeConn
+}
+
+// MemcacheError is an error reply of the memcached server.
+type MemcacheError struct {
+	Message string
+}
+
+func (e *MemcacheError) Error() string {
+	return "memcache: " + e.Message
+}
+
+func (s *MemcacheStore) TryAcquire(ctx context.Context, key string, window time.Duration) (bool, error) {
+	reply, err := s.do(ctx, s.storage("add", key, window))
+	if err != nil {
+		return false, err
+	}
+	// NOT_STORED means another replica holds the key.
+	return reply == "STORED", nil
+}
+
+func (s *MemcacheStore) MarkSent(ctx context.Context, key string, window time.Duration) error {
+	_, err := s.do(ctx, s.storage("set", key, window))
+	return err
+}
+
+func (s *MemcacheStore) Release(ctx context.Context, key string) error {
+	// NOT_FOUND means the key expired already.
+	_, err := s.do(ctx, "delete "+s.key(key)+"\r\n")
+	return err
+}
+
+// Close closes the connection to the server.
+func (s *MemcacheStore) Close() error {
+	return s.conn.close()
+}
+
+// key hashes the key, so long messages make keys within the limit of memcached.
+func (s *MemcacheStore) key(key string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "log_hooks:"
+	}
+	return hashedKey(prefix, key)
+}
+
+// storage formats a storage command for the key with the window as expiry.
+func (s *MemcacheStore) storage(command string, key string, window time.Duration) string {
+	// Relative expiry, memcached takes larger values as a Unix time and 0 as never.
+	expiry := max(min(int64((window+time.Second-1)/time.Second), 30*24*60*60), 1)
+	return fmt.Sprintf("%s %s 0 %d 1\r\n1\r\n", command, s.key(key), expiry)
+}
+
+// do sends the request and reads the reply line, reconnecting when the connection broke.
+func (s *MemcacheStore) do(ctx context.Context, request string) (string, error) {
+	if err := s.conn.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer s.conn.release()
+
+	if s.conn.conn == nil {
+		if err := s.conn.open(ctx, s.Dialer, s.Addr, s.TLSConfig); err != nil {
+			return "", err
+		}
+	}
+	reply, err := s.command(ctx, request)
+	var memcacheErr *MemcacheError
+	if err != nil && !errors.As(err, &memcacheErr) {
+		_ = s.conn.drop()
+	}
+	return reply, err
+}
+
+func (s *MemcacheStore) command(ctx context.Context, request string) (string, error) {
+	defer s.conn.bound(ctx)()
+
+	if _, err := io.WriteString(s.conn.conn, request); err != nil {
+		return "", err
+	}
+	line, err := s.conn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case line == "STORED" || line == "NOT_STORED" || line == "DELETED" || line == "NOT_FOUND":
+		return line, nil
+	case line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR ") || strings.HasPrefix(line, "SERVER_ERROR "):
+		return "", &MemcacheError{Message: line}
+	default:
+		return "", fmt.Errorf("memcache: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,246 @@
+package log_hooks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testMemcache is an in-process memcached speaking the add, set and delete commands.
+type testMemcache struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	items    map[string]time.Time
+	commands []string
+}
+
+func newTestMemcache(t *testing.T) *testMemcache {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &testMemcache{ln: ln, items: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testMemcache) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_, _ = conn.Write([]byte("ERROR\r\n"))
+			continue
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.TrimSpace(line))
+		s.mu.Unlock()
+		_, _ = conn.Write([]byte(s.handle(fields, r) + "\r\n"))
+	}
+}
+
+func (s *testMemcache) handle(fields []string, r *bufio.Reader) string {
+	switch fields[0] {
+	case "add", "set":
+		if len(fields) != 5 {
+			return "ERROR"
+		}
+		expiry, err := strconv.Atoi(fields[3])
+		size, sizeErr := strconv.Atoi(fields[4])
+		if err != nil || sizeErr != nil {
+			return "CLIENT_ERROR bad command line format"
+		}
+		if _, err := r.Discard(size + 2); err != nil {
+			return "CLIENT_ERROR bad data chunk"
+		}
+		if len(fields[1]) > 250 {
+			return "CLIENT_ERROR key too long"
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if expires, ok := s.items[fields[1]]; ok && fields[0] == "add" && time.Now().Before(expires) {
+			return "NOT_STORED"
+		}
+		s.items[fields[1]] = time.Now().Add(time.Duration(expiry) * time.Second)
+		return "STORED"
+	case "delete":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.items[fields[1]]; !ok {
+			return "NOT_FOUND"
+		}
+		delete(s.items, fields[1])
+		return "DELETED"
+	case "fail":
+		return "SERVER_ERROR out of memory"
+	}
+	return "ERROR"
+}
+
+func (s *testMemcache) commandLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func TestMemcacheStore(t *testing.T) {
+	server := newTestMemcache(t)
+	store := &MemcacheStore{Addr: server.ln.Addr().String()}
+	defer func() { _ = store.Close() }()
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		ok, err := store.TryAcquire(ctx, "disk full", 1500*time.Millisecond)
+		if err != nil || ok != want {
+			t.Fatalf("acquire %d: got %v, %v, want %v", i+1, ok, err, want)
+		}
+	}
+	if err := store.MarkSent(ctx, "disk full", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(ctx, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	// Releasing an expired claim is fine too.
+	if err := store.Release(ctx, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.TryAcquire(ctx, "disk full", time.Minute); err != nil || !ok {
+		t.Fatalf("acquire after release: got %v, %v", ok, err)
+	}
+
+	// Windows are rounded up to seconds within what memcached takes as relative.
+	commands := server.commandLog()
+	key := hashedKey("log_hooks:", "disk full")
+	for i, want := range []string{"add " + key + " 0 2 1", "add " + key + " 0 2 1", "set " + key + " 0 600 1", "delete " + key} {
+		if commands[i] != want {
+			t.Fatalf("command %d: got %q, want %q", i, commands[i], want)
+		}
+	}
+	for window, want := range map[time.Duration]string{0: " 0 1 1", 60 * 24 * time.Hour: " 0 2592000 1"} {
+		if request := store.storage("add", "k", window); !strings.Contains(request, want) {
+			t.Errorf("window %s: got %q", window, request)
+		}
+	}
+}
+
+func TestMemcacheStoreLongKeys(t *testing.T) {
+	server := newTestMemcache(t)
+	store := &MemcacheStore{Addr: server.ln.Addr().String(), Prefix: "billing:"}
+	defer func() { _ = store.Close() }()
+
+	message := strings.Repeat("very long error message ", 100)
+	if ok, err := store.TryAcquire(context.Background(), message, time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if command := server.commandLog()[0]; !strings.HasPrefix(command, "add billing:") || len(command) > 300 {
+		t.Fatalf("got command %q", command)
+	}
+}
+
+func TestMemcacheStoreErrors(t *testing.T) {
+	server := newTestMemcache(t)
+	store := &MemcacheStore{Addr: server.ln.Addr().String()}
+	defer func() { _ = store.Close() }()
+
+	_, err := store.do(context.Background(), "fail\r\n")
+	var memcacheErr *MemcacheError
+	if !errors.As(err, &memcacheErr) || memcacheErr.Message != "SERVER_ERROR out of memory" {
+		t.Fatalf("got %v, want a MemcacheError", err)
+	}
+	// An error reply keeps the connection.
+	if ok, err := store.TryAcquire(context.Background(), "k", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+
+	down := &MemcacheStore{Addr: "127.0.0.1:1"}
+	if _, err := down.TryAcquire(context.Background(), "k", time.Minute); err == nil {
+		t.Fatal("unreachable server acquired")
+	}
+}
+
+func TestMemcacheStoreHooks(t *testing.T) {
+	memcache := newTestMemcache(t)
+	server := newTestServer(t, nil)
+	newHook := func(opts ...MailOption) *MailHook {
+		opts = append([]MailOption{WithoutConnectivityCheck()}, opts...)
+		hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hook
+	}
+	shared := func() MailOption {
+		return WithSuppressionStore(&MemcacheStore{Addr: memcache.ln.Addr().String()})
+	}
+
+	// Two replicas send the error once.
+	replicas := []*MailHook{newHook(shared(), WithSuppressionWindows(0, time.Hour)), newHook(shared(), WithSuppressionWindows(0, time.Hour))}
+	for _, hook := range replicas {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "incident")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+
+	// A failed send gives the claim back, so the other replica sends.
+	server.rejectData.Store(1)
+	if err := replicas[0].Fire(testEntry(logrus.ErrorLevel, "lost")); err == nil {
+		t.Fatal("rejected send succeeded")
+	}
+	if err := replicas[1].Fire(testEntry(logrus.ErrorLevel, "lost")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 2 {
+		t.Fatalf("got %d messages, want 2", n)
+	}
+
+	// An outage fails open and is reported once.
+	var mu sync.Mutex
+	var reported []error
+	down := newHook(WithSuppressionStore(&MemcacheStore{Addr: "127.0.0.1:1"}), WithSuppressionWindows(0, time.Hour),
+		WithOnError(func(_ *logrus.Entry, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		}))
+	for _, message := range []string{"outage 1", "outage 2"} {
+		if err := down.Fire(testEntry(logrus.ErrorLevel, message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(server.received()); n != 4 {
+		t.Fatalf("got %d messages, want 4", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "suppression store unavailable") {
+		t.Fatalf("got reported %v", reported)
+	}
+}
@@ -0,0 +1,540 @@
+package log_hooks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"runtime/debug"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSubjectTemplate renders subjects as "appName - level".
+const DefaultSubjectTemplate = "{{.AppName}} - {{.Level}}"
+
+// DefaultMaxSubjectLength is the subject length limit in characters used when none is configured.
+const DefaultMaxSubjectLength = 255
+
+// DefaultTimeLayout is the layout of times in emails used when none is configured.
+const DefaultTimeLayout = "2006-01-02 15:04:05-0700"
+
+// DefaultBodyTemplate is the layout of the email body used when none is configured.
+// It relies on the "json" function, so custom templates based on it are parsed with TemplateFuncs.
+const DefaultBodyTemplate = `TIME: {{.FormattedTime}}
+HOST: {{.Hostname}} (pid {{.PID}})
+{{if .Environment}}ENV: {{.Environment}}
+{{end}}GO: {{.GoVersion}}
+{{if .Build}}BUILD: {{.Build}}
+{{end}}MESSAGE: {{.Message}}
+{{if .Caller}}CALLER: {{.Caller}}
+{{end}}{{range .Notes}}NOTE: {{.}}
+{{end}}
+DATA: {{json .Data}}{{if .History}}
+
+HISTORY:
+{{range .History}}{{.}}
+{{end}}{{end}}{{if .Stack}}
+
+STACKTRACE: 
+{{.Stack}}{{end}}`
+
+const htmlBodyTemplate = `<html>
+<body>
+<p><b>TIME:</b> {{.FormattedTime}}</p>
+<p><b>HOST:</b> {{.Hostname}} (pid {{.PID}})</p>
+{{if .Environment}}<p><b>ENV:</b> {{.Environment}}</p>
+{{end}}<p><b>GO:</b> {{.GoVersion}}</p>
+{{if .Build}}<p><b>BUILD:</b> {{.Build}}</p>
+{{end}}<p><b>MESSAGE:</b> {{.Message}}</p>
+{{if .Caller}}<p><b>CALLER:</b> {{.Caller}}</p>
+{{end}}{{range .Notes}}<p><b>NOTE:</b> {{.}}</p>
+{{end}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Field</th><th>Value</th></tr>
+{{range $key, $value := .Data}}<tr><td>{{$key}}</td><td>{{$value}}</td></tr>
+{{end}}</table>
+{{if .History}}<p><b>HISTORY:</b></p>
+<pre>{{range .History}}{{.}}
+{{end}}</pre>
+{{end}}{{if .Stack}}<p><b>STACKTRACE:</b></p>
+<pre>{{.Stack}}</pre>
+{{end}}</body>
+</html>`
+
+var defaultSubjectTemplate = template.Must(template.New("subject").Parse(DefaultSubjectTemplate))
+
+var defaultBodyTemplate = template.Must(template.New("body").Funcs(TemplateFuncs(nil)).Parse(DefaultBodyTemplate))
+
+var defaultHTMLBodyTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(htmlBodyTemplate))
+
+// SubjectData is passed to the subject template.
+type SubjectData struct {
+	// Time is in the zone set with WithTimeFormat, FormattedTime uses its layout.
+	Time          time.Time
+	FormattedTime string
+	AppName       string
+	Level         string
+	Message       string
+	// Hostname and PID are looked up once when the hook is created.
+	Hostname    string
+	PID         int
+	Environment string
+	Fields      logrus.Fields
+}
+
+// BodyData is passed to the body template.
+type BodyData struct {
+	// Time is in the zone set with WithTimeFormat, FormattedTime uses its layout.
+	Time          time.Time
+	FormattedTime string
+	Level         string
+	Message       string
+	Data          logrus.Fields
+	AppName       string
+	// Hostname, PID, GoVersion and Build, the main module and its VCS revision, are looked
+	// up once when the hook is created. Environment is set with WithEnvironment.
+	Hostname    string
+	PID         int
+	Environment string
+	GoVersion   string
+	Build       string
+	// Notes tell the reader what was removed from the email and how often it was suppressed.
+	Notes []string
+	// Suppressed is the number of times the error occurred since it was last sent at LastSent,
+	// which is zero when it wasn't sent before.
+	Suppressed int
+	LastSent   time.Time
+	// SuppressedFor is how long repeats of the error are suppressed after this email,
+	// set with WithBackoffSuppression.
+	SuppressedFor time.Duration
+	// Caller is "function file:line" of the logging call when the logger reports callers.
+	Caller string
+	// History is what was logged before the entry, see WithHistory.
+	History []string
+	// Stack is empty for entries less severe than the stack trace level, see WithStackTraceLevel,
+	// and when Caller is set, as it would only show the hook itself. Panic and fatal entries
+	// carry the stacks of all goroutines, see WithoutGoroutineDump.
+	Stack string
+
+	goroutineDump bool
+}
+
+// WithSubjectTemplate sets a text/template for the email subject.
+func WithSubjectTemplate(text string) MailOption {
+	return func(o *mailOptions) error {
+		// Parsed again with all the functions when the hook is created, see WithTemplateFuncs,
+		// which also reports syntax errors.
+		o.subjectText = text
+		o.subjectTemplate, _ = template.New("subject").Funcs(TemplateFuncs(o.templateFuncs)).Parse(text)
+		return nil
+	}
+}
+
+// WithMaxSubjectLength truncates subjects longer than n characters.
+func WithMaxSubjectLength(n int) MailOption {
+	return func(o *mailOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("max subject length must be positive, got %d", n)
+		}
+		o.maxSubjectLength = n
+		return nil
+	}
+}
+
+// WithBodyTemplate sets the template for the email body, executed with BodyData.
+// If execution fails the default body is sent instead.
+func WithBodyTemplate(tmpl *template.Template) MailOption {
+	return func(o *mailOptions) error {
+		if tmpl == nil {
+			return errors.New("body template must not be nil")
+		}
+		o.bodyTemplate = tmpl
+		return nil
+	}
+}
+
+type bodyFormat int
+
+const (
+	bodyText bodyFormat = iota
+	bodyHTML
+	bodyAlternative
+)
+
+// WithStackTraceLevel sets the least severe level whose emails include the stack trace,
+// by default error. Less severe entries are sent without the STACKTRACE section, see also WithoutStackTrace.
+func WithStackTraceLevel(level logrus.Level) MailOption {
+	return func(o *mailOptions) error {
+		if _, err := level.MarshalText(); err != nil {
+			return err
+		}
+		o.stack.level = &level
+		return nil
+	}
+}
+
+// WithTimeFormat sets the layout and the zone of times in emails, by default DefaultTimeLayout
+// in the zone of the entry. A nil location keeps the zone of WithTimeLocation or of the entry.
+// The month and weekday names can be translated with WithTimeNames.
+func WithTimeFormat(layout string, location *time.Location) MailOption {
+	return func(o *mailOptions) error {
+		if layout == "" {
+			return errors.New("time layout must not be empty")
+		}
+		o.timeLayout = layout
+		if location != nil {
+			o.timeLocation = location
+		}
+		return nil
+	}
+}
+
+func (m *mailer) localTime(t time.Time) time.Time {
+	if m.options.timeLocation != nil {
+		return t.In(m.options.timeLocation)
+	}
+	return t
+}
+
+func (m *mailer) formatTime(t time.Time) string {
+	layout := m.options.timeLayout
+	if layout == "" {
+		layout = DefaultTimeLayout
+	}
+	if m.options.timeNames != nil {
+		return m.options.timeNames.format(m.localTime(t), layout)
+	}
+	return m.localTime(t).Format(layout)
+}
+
+// WithHTMLBody sends the body as HTML with a table of fields instead of plain text.
+func WithHTMLBody() MailOption {
+	return func(o *mailOptions) error {
+		o.bodyFormat = bodyHTML
+		return nil
+	}
+}
+
+// WithMultipartBody sends both the plain text and the HTML body as multipart/alternative.
+func WithMultipartBody() MailOption {
+	return func(o *mailOptions) error {
+		o.bodyFormat = bodyAlternative
+		return nil
+	}
+}
+
+func (m *mailer) subject(entry *logrus.Entry) string {
+	data := SubjectData{
+		Time:          m.localTime(entry.Time),
+		FormattedTime: m.formatTime(entry.Time),
+		AppName:       m.appName,
+		Level:         entry.Level.String(),
+		Message:       m.redactMessage(entry.Message),
+		Hostname:      m.hostname,
+		PID:           m.pid,
+		Environment:   m.options.environment,
+		Fields:        m.redactFields(entry.Data),
+	}
+
+	tmpl := m.options.subjectTemplate
+	if tmpl == nil {
+		tmpl = defaultSubjectTemplate
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		m.reportError(entry, fmt.Errorf("subject template: %w", err))
+		buf.Reset()
+		_ = defaultSubjectTemplate.Execute(&buf, data)
+	}
+
+	// Newlines would break the header block.
+	subject := strings.Join(strings.Fields(buf.String()), " ")
+
+	maxLength := m.options.maxSubjectLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxSubjectLength
+	}
+	if runes := []rune(subject); len(runes) > maxLength {
+		subject = string(runes[:maxLength-1]) + "…"
+	}
+	return subject
+}
+
+// headerData holds the values of the header block that vary between emails.
+type headerData struct {
+	recipients []string
+	subject    string
+	date       time.Time
+	level      logrus.Level
+	// messageID is generated when empty.
+	messageID string
+	// inReplyTo is the Message-ID of the first email sent for the same error,
+	// so mail clients show repeated alerts as one conversation.
+	inReplyTo string
+	// suppressFor is the window of the error after this email, see WithBackoffSuppression.
+	suppressFor time.Duration
+}
+
+// createMessage renders the entry, the header fills in the recipients and the threading.
+// suppressed is the number of entries suppressed since the error was last sent at lastSent.
+func (m *mailer) createMessage(entry *logrus.Entry, header headerData, suppressed int, lastSent time.Time) (*bytes.Buffer, error) {
+	header.subject = m.subject(entry)
+	header.date = entry.Time
+	header.level = entry.Level
+
+	data := m.bodyData(entry)
+	data.History = m.history(entry)
+	if suppressed > 0 {
+		data.Suppressed = suppressed
+		data.LastSent = m.localTime(lastSent)
+		note := fmt.Sprintf("occurred %d more times", suppressed)
+		if !lastSent.IsZero() {
+			note += " since last notification at " + m.formatTime(lastSent)
+		}
+		data.Notes = append(data.Notes, note)
+	}
+	if header.suppressFor > 0 {
+		data.SuppressedFor = header.suppressFor
+		data.Notes = append(data.Notes, "repeats are suppressed for "+header.suppressFor.String())
+	}
+
+	return m.limitMessage(data, func(data BodyData) (*bytes.Buffer, error) {
+		render := m.content
+		if m.shouldAttach(data) {
+			render = m.mixedBody
+		}
+		body, err := m.limitBody(data, render)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.assemble(header, body), nil
+	})
+}
+
+// mimePart is a rendered body together with its Content-Type and Content-Transfer-Encoding.
+// An empty encoding means the body is sent as is.
+type mimePart struct {
+	contentType string
+	encoding    string
+	body        string
+}
+
+// textPart encodes text as quoted-printable so UTF-8 content survives 7-bit relays.
+func textPart(contentType string, text string) mimePart {
+	var buf strings.Builder
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = io.WriteString(w, text)
+	_ = w.Close()
+	return mimePart{contentType: contentType, encoding: "quoted-printable", body: buf.String()}
+}
+
+// header returns the MIME header of the part inside a multipart body.
+func (p mimePart) header() textproto.MIMEHeader {
+	header := textproto.MIMEHeader{"Content-Type": {p.contentType}}
+	if p.encoding != "" {
+		header.Set("Content-Transfer-Encoding", p.encoding)
+	}
+	return header
+}
+
+// assemble builds a message with the standard header block and the priority of the level.
+// Non-ASCII subjects are encoded as RFC 2047 encoded-words.
+func (m *mailer) assemble(h headerData, part mimePart) *bytes.Buffer {
+	date := h.date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := h.messageID
+	if messageID == "" {
+		messageID = newMessageID(m.hostname)
+	}
+
+	fields := []headerField{{"From", headerAddress(m.from)}}
+	if len(m.options.replyTo) > 0 {
+		fields = append(fields, headerField{"Reply-To", m.addresses.header(m.options.replyTo)})
+	}
+	fields = append(fields, headerField{"To", m.addresses.header(h.recipients)})
+	if len(m.options.cc) > 0 {
+		fields = append(fields, headerField{"Cc", m.addresses.header(m.options.cc)})
+	}
+	fields = append(fields,
+		headerField{"Subject", mime.QEncoding.Encode("utf-8", h.subject)},
+		headerField{"Date", date.Format(time.RFC1123Z)},
+		headerField{"Message-ID", messageID},
+	)
+	if h.inReplyTo != "" {
+		fields = append(fields,
+			headerField{"In-Reply-To", h.inReplyTo},
+			headerField{"References", h.inReplyTo},
+		)
+	}
+	fields = append(fields,
+		headerField{"MIME-Version", "1.0"},
+		headerField{"Content-Type", part.contentType},
+	)
+	if part.encoding != "" {
+		fields = append(fields, headerField{"Content-Transfer-Encoding", part.encoding})
+	}
+	fields = append(fields, m.priorityHeaders(h.level)...)
+
+	var header strings.Builder
+	for _, field := range m.applyCustomHeaders(fields) {
+		writeHeader(&header, field.name, field.value)
+	}
+
+	message := []byte(fmt.Sprintf("%s\r\n%s", header.String(), part.body))
+	if m.options.dkim != nil {
+		signed, err := m.options.dkim.sign(message)
+		if err != nil {
+			m.reportError(nil, err)
+		} else {
+			message = signed
+		}
+	}
+	return bytes.NewBuffer(message)
+}
+
+func (m *mailer) bodyData(entry *logrus.Entry) BodyData {
+	fields, omitted := m.filterFields(entry.Data)
+	fields, failed := encodeFields(m.redactFields(fields))
+	data := BodyData{
+		Time:          m.localTime(entry.Time),
+		FormattedTime: m.formatTime(entry.Time),
+		Level:         entry.Level.String(),
+		Message:       m.redactMessage(entry.Message),
+		Data:          truncateFields(fields, m.options.maxFieldSize),
+		AppName:       m.appName,
+		Hostname:      m.hostname,
+		PID:           m.pid,
+		Environment:   m.options.environment,
+		GoVersion:     m.goVersion,
+		Build:         m.build,
+	}
+	if omitted > 0 {
+		data.Notes = append(data.Notes, fmt.Sprintf("%d field(s) omitted", omitted))
+	}
+	if len(failed) > 0 {
+		data.Notes = append(data.Notes, "field(s) "+strings.Join(failed, ", ")+" not serializable as JSON, shown with %+v")
+	}
+	if entry.Caller != nil {
+		data.Caller = fmt.Sprintf("%s %s:%d", entry.Caller.Function, entry.Caller.File, entry.Caller.Line)
+	}
+	switch {
+	case !m.includesStack(entry.Level):
+	case m.dumpsGoroutines(entry.Level):
+		data.Stack = m.goroutineDump()
+		data.goroutineDump = true
+	case entry.Caller == nil:
+		data.Stack = string(debug.Stack())
+	}
+	return data
+}
+
+// includesStack reports whether emails for the level carry a stack trace.
+func (m *mailer) includesStack(level logrus.Level) bool {
+	return m.options.stack.includes(level, logrus.ErrorLevel)
+}
+
+func (m *mailer) body(data BodyData) string {
+	if tmpl := m.options.bodyTemplate; tmpl != nil {
+		var buf strings.Builder
+		err := tmpl.Execute(&buf, data)
+		if err == nil {
+			return buf.String()
+		}
+		m.reportError(nil, fmt.Errorf("body template: %w", err))
+	}
+
+	var buf strings.Builder
+	_ = defaultBodyTemplate.Execute(&buf, data)
+	return buf.String()
+}
+
+func (m *mailer) htmlBody(data BodyData) string {
+	var buf strings.Builder
+	if err := defaultHTMLBodyTemplate.Execute(&buf, data); err != nil {
+		return htmltemplate.HTMLEscapeString(m.body(data))
+	}
+	return buf.String()
+}
+
+// content renders the body in the configured format.
+func (m *mailer) content(data BodyData) mimePart {
+	switch m.options.bodyFormat {
+	case bodyHTML:
+		return textPart("text/html; charset=utf-8", m.htmlBody(data))
+	case bodyAlternative:
+		return m.alternativeBody(data)
+	default:
+		return textPart("text/plain; charset=utf-8", m.body(data))
+	}
+}
+
+// alternativeBody renders a multipart/alternative body with the text part first.
+func (m *mailer) alternativeBody(data BodyData) mimePart {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	parts := []mimePart{
+		textPart("text/plain; charset=utf-8", m.body(data)),
+		textPart("text/html; charset=utf-8", m.htmlBody(data)),
+	}
+	for _, part := range parts {
+		w, _ := writer.CreatePart(part.header())
+		_, _ = io.WriteString(w, part.body)
+	}
+	_ = writer.Close()
+
+	return mimePart{contentType: "multipart/alternative; boundary=" + writer.Boundary(), body: buf.String()}
+}
+
+func jsonIndent(v interface{}) string {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return string(data)
+}
+
+// maxHeaderLine is the line length recommended by RFC 5322.
+const maxHeaderLine = 78
+
+// writeHeader writes a CRLF-terminated header field, folding long values at spaces.
+func writeHeader(w *strings.Builder, name string, value string) {
+	// A CR or LF in a value would end the field and let it inject headers.
+	value = headerNewlines.Replace(value)
+	line := name + ":"
+	for _, word := range strings.Split(value, " ") {
+		if len(line) > len(name)+1 && len(line)+1+len(word) > maxHeaderLine {
+			w.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	w.WriteString(line + "\r\n")
+}
+
+var headerNewlines = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// newMessageID returns a unique Message-ID in the given domain.
+func newMessageID(domain string) string {
+	if domain == "" {
+		domain = "localhost"
+	}
+	var random [8]byte
+	_, _ = rand.Read(random[:])
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random[:]), domain)
+}
@@ -0,0 +1,231 @@
+package log_hooks
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newDryRunHook creates a hook recording its messages instead of sending them.
+func newDryRunHook(t *testing.T, opts ...MailOption) *MailHook {
+	t.Helper()
+	opts = append([]MailOption{WithoutConnectivityCheck(), WithDryRun(nil)}, opts...)
+	hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// fireMessage fires the entry and returns the message the hook rendered for it.
+func fireMessage(t *testing.T, hook *MailHook, entry *logrus.Entry) *mail.Message {
+	t.Helper()
+	before := len(hook.DryRunMessages())
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	messages := hook.DryRunMessages()
+	if len(messages) != before+1 {
+		t.Fatalf("entry %q was not sent", entry.Message)
+	}
+	return parseMessage(t, string(messages[len(messages)-1]))
+}
+
+// decodedBody decodes the body of a single part message.
+func decodedBody(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+	body := msg.Body
+	if msg.Header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+		body = quotedprintable.NewReader(body)
+	}
+	text, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+// testPart is a decoded part of a multipart message.
+type testPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// multipartBody checks that the message is of the multipart media type and returns its
+// decoded parts. It fails when a boundary is missing or the closing delimiter isn't there.
+func multipartBody(t *testing.T, msg *mail.Message, mediaType string) []testPart {
+	t.Helper()
+	got, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || got != mediaType || params["boundary"] == "" {
+		t.Fatalf("got Content-Type %q, want %s with a boundary", msg.Header.Get("Content-Type"), mediaType)
+	}
+	var parts []testPart
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		// NextPart would decode quoted-printable itself and drop the header.
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("part %d: %v", len(parts)+1, err)
+		}
+		var body io.Reader = part
+		switch part.Header.Get("Content-Transfer-Encoding") {
+		case "quoted-printable":
+			body = quotedprintable.NewReader(part)
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		text, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("part %d: %v", len(parts)+1, err)
+		}
+		parts = append(parts, testPart{header: part.Header, body: string(text)})
+	}
+}
+
+func decodedSubject(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subject
+}
+
+func TestUTF8Message(t *testing.T) {
+	hook := newDryRunHook(t, WithSubjectTemplate("{{.AppName}}: {{.Message}}"))
+	entry := testEntry(logrus.ErrorLevel, "Ошибка подключения 🔥")
+	entry.Data["пользователь"] = "Иван 😀"
+
+	msg := fireMessage(t, hook, entry)
+
+	raw := string(hook.DryRunMessages()[0])
+	for i := 0; i < len(raw); i++ {
+		if raw[i] >= 0x80 {
+			t.Fatalf("non-ASCII byte at %d in %q", i, raw)
+		}
+	}
+	if subject := decodedSubject(t, msg); !strings.Contains(subject, "Ошибка подключения 🔥") {
+		t.Fatalf("got subject %q", subject)
+	}
+	if contentType := msg.Header.Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("got Content-Type %q", contentType)
+	}
+	body := decodedBody(t, msg)
+	for _, want := range []string{"Ошибка подключения 🔥", "пользователь", "Иван 😀"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestASCIISubjectNotEncoded(t *testing.T) {
+	hook := newDryRunHook(t, WithSubjectTemplate("{{.AppName}}: {{.Message}}"))
+	msg := fireMessage(t, hook, testEntry(logrus.ErrorLevel, "plain ascii"))
+	if subject := msg.Header.Get("Subject"); strings.Contains(subject, "=?") || !strings.Contains(subject, "plain ascii") {
+		t.Fatalf("got subject %q", subject)
+	}
+}
+
+func TestContentTransferEncodingReserved(t *testing.T) {
+	_, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithHeader("Content-Transfer-Encoding", "8bit"))
+	if err == nil {
+		t.Fatal("Content-Transfer-Encoding set with WithHeader")
+	}
+}
+
+func TestCaller(t *testing.T) {
+	for _, reportCaller := range []bool{true, false} {
+		hook := newDryRunHook(t)
+		logger := logrus.New()
+		logger.Out = io.Discard
+		logger.SetReportCaller(reportCaller)
+		logger.AddHook(hook)
+
+		logger.Error("with caller")
+
+		messages := hook.DryRunMessages()
+		if len(messages) != 1 {
+			t.Fatalf("got %d messages, want 1", len(messages))
+		}
+		body := decodedBody(t, parseMessage(t, string(messages[0])))
+		caller := strings.Contains(body, "CALLER: ") && strings.Contains(body, "TestCaller") &&
+			strings.Contains(body, "message_test.go:")
+		stack := strings.Contains(body, "STACKTRACE:")
+		if caller != reportCaller || stack == reportCaller {
+			t.Fatalf("ReportCaller %v: caller %v, stack trace %v:\n%s", reportCaller, caller, stack, body)
+		}
+	}
+}
+
+func TestHTMLBody(t *testing.T) {
+	hook := newDryRunHook(t, WithHTMLBody())
+	entry := testEntry(logrus.ErrorLevel, `<script>alert("pwned")</script>`)
+	entry.Data["query"] = "a < b && c > d"
+
+	msg := fireMessage(t, hook, entry)
+
+	if contentType := msg.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Fatalf("got Content-Type %q", contentType)
+	}
+	body := decodedBody(t, msg)
+	if strings.Contains(body, "<script>") || strings.Contains(body, "a < b") {
+		t.Fatalf("body isn't escaped:\n%s", body)
+	}
+	for _, want := range []string{"&lt;script&gt;alert(&#34;pwned&#34;)&lt;/script&gt;",
+		"<tr><td>query</td><td>a &lt; b &amp;&amp; c &gt; d</td></tr>", "<pre>goroutine "} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("body doesn't contain %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestMultipartBody(t *testing.T) {
+	entry := testEntry(logrus.ErrorLevel, "Ошибка: <b>"+strings.Repeat("long line ", 20))
+	entry.Data["user"] = "Иван"
+	plain := decodedBody(t, fireMessage(t, newDryRunHook(t), entry))
+	hook := newDryRunHook(t, WithMultipartBody())
+
+	msg := fireMessage(t, hook, entry)
+
+	if version := msg.Header.Get("MIME-Version"); version != "1.0" {
+		t.Fatalf("got MIME-Version %q", version)
+	}
+	raw := string(hook.DryRunMessages()[0])
+	for _, line := range strings.Split(raw, "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line of %d bytes", len(line))
+		}
+	}
+	parts := multipartBody(t, msg, "multipart/alternative")
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	for i, want := range []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"} {
+		if got := parts[i].header.Get("Content-Type"); got != want {
+			t.Fatalf("part %d: got Content-Type %q, want %q", i+1, got, want)
+		}
+		if got := parts[i].header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+			t.Fatalf("part %d: got Content-Transfer-Encoding %q", i+1, got)
+		}
+	}
+	// The text part is the plain body, up to the stack trace taken for each email.
+	text, _, _ := strings.Cut(parts[0].body, "STACKTRACE:")
+	if want, _, _ := strings.Cut(plain, "STACKTRACE:"); text != want {
+		t.Fatalf("got text part:\n%s\nwant the plain body:\n%s", text, want)
+	}
+	if html := parts[1].body; !strings.Contains(html, "Ошибка: &lt;b&gt;") || !strings.Contains(html, "<td>Иван</td>") {
+		t.Fatalf("got HTML part:\n%s", html)
+	}
+}
@@ -0,0 +1,44 @@
+package log_hooks
+
+import (
+	"errors"
+	"regexp"
+)
+
+// NormalizeRule replaces the matches of Pattern in a message with Replacement, which may
+// refer to submatches like regexp.Regexp.ReplaceAllString.
+type NormalizeRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultNormalizeRules replace UUIDs with "<uuid>", numbers with "<n>" and hex IDs of at
+// least 8 digits or with a 0x prefix with "<hex>", in this order.
+var DefaultNormalizeRules = []NormalizeRule{
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d+(?:\.\d+)?\b`), "<n>"},
+	{regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`), "<hex>"},
+}
+
+// WithDedupNormalization normalizes the message before it is keyed, so "timeout fetching
+// user 48211" and "timeout fetching user 99231" are the same error. The rules are applied
+// first, then DefaultNormalizeRules. The email still shows the original message.
+func WithDedupNormalization(rules ...NormalizeRule) MailOption {
+	return func(o *mailOptions) error {
+		for _, rule := range rules {
+			if rule.Pattern == nil {
+				return errors.New("normalize rule pattern must not be nil")
+			}
+		}
+		o.normalizeRules = append(append(o.normalizeRules, rules...), DefaultNormalizeRules...)
+		return nil
+	}
+}
+
+// NormalizeMessage applies the rules to the message in order.
+func NormalizeMessage(message string, rules []NormalizeRule) string {
+	for _, rule := range rules {
+		message = rule.Pattern.ReplaceAllString(message, rule.Replacement)
+	}
+	return message
+}
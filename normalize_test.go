@@ -0,0 +1,86 @@
+package log_hooks
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNormalizeMessageCorpus(t *testing.T) {
+	rules := append([]NormalizeRule{{regexp.MustCompile(`user=\w+`), "user=<user>"}}, DefaultNormalizeRules...)
+	groups := map[string][]string{
+		"timeout fetching user <n>": {
+			"timeout fetching user 48211",
+			"timeout fetching user 99231",
+			"timeout fetching user 7",
+		},
+		"request <uuid> failed after <n> seconds": {
+			"request 123e4567-e89b-12d3-a456-426614174000 failed after 1.5 seconds",
+			"request 9F8B7C6D-1A2B-4C3D-8E9F-0A1B2C3D4E5F failed after 30 seconds",
+		},
+		"object <hex> not found in bucket logs": {
+			"object deadbeefcafe not found in bucket logs",
+			"object 5f2b9c1ad3e4 not found in bucket logs",
+			"object 0x1f not found in bucket logs",
+		},
+		"login failed for user=<user> from <n>.<n>": {
+			"login failed for user=alice from 10.0.0.1",
+			"login failed for user=bob from 192.168.10.254",
+		},
+		"connection refused": {
+			"connection refused",
+		},
+		// Short hex-like words and words with digits are kept.
+		"cafe v2 unavailable": {
+			"cafe v2 unavailable",
+		},
+	}
+
+	keys := make(map[string]string)
+	for want, messages := range groups {
+		for _, message := range messages {
+			got := NormalizeMessage(message, rules)
+			if got != want {
+				t.Errorf("%q: got %q, want %q", message, got, want)
+			}
+			if other, ok := keys[got]; ok && other != want {
+				t.Errorf("%q grouped with %q", message, other)
+			}
+			keys[got] = want
+		}
+	}
+}
+
+func TestDedupNormalization(t *testing.T) {
+	hook := newDryRunHook(t, WithSuppressionWindows(0, time.Hour),
+		WithDedupNormalization(NormalizeRule{regexp.MustCompile(`user=\w+`), "user=<user>"}))
+
+	sent := countSent(t, hook,
+		testEntry(logrus.ErrorLevel, "timeout fetching user 48211"),
+		testEntry(logrus.ErrorLevel, "timeout fetching user 99231"),
+		testEntry(logrus.ErrorLevel, "login failed for user=alice"),
+		testEntry(logrus.ErrorLevel, "login failed for user=bob"),
+	)
+	if sent != 2 {
+		t.Fatalf("got %d emails, want 2", sent)
+	}
+
+	// The email shows the original message, only the key is normalized.
+	messages := hook.DryRunMessages()
+	for i, want := range []string{"timeout fetching user 48211", "login failed for user=alice"} {
+		if body := decodedBody(t, parseMessage(t, string(messages[i]))); !strings.Contains(body, want) {
+			t.Fatalf("email %d doesn't contain %q:\n%s", i, want, body)
+		}
+	}
+}
+
+func TestDedupNormalizationNilPattern(t *testing.T) {
+	_, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithDedupNormalization(NormalizeRule{Replacement: "x"}))
+	if err == nil {
+		t.Fatal("nil pattern accepted")
+	}
+}
@@ -0,0 +1,109 @@
+package log_hooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorHandler is called when a hook fails to deliver an entry, including background
+// sends whose errors Fire can't return. entry is nil for failures not tied to one entry.
+type ErrorHandler func(entry *logrus.Entry, err error)
+
+// WithOnError calls fn whenever the hook fails, possibly from several goroutines at once.
+// fn may log through the hooked logger: failures of what it logs are printed to stderr
+// instead of calling it again.
+func WithOnError(fn ErrorHandler) MailOption {
+	return func(o *mailOptions) error {
+		if fn == nil {
+			return errors.New("error handler must not be nil")
+		}
+		o.onError = fn
+		return nil
+	}
+}
+
+// errorReporter calls an ErrorHandler, guarding against recursion.
+type errorReporter struct {
+	handler ErrorHandler
+
+	// busy are the goroutines running the handler.
+	mu   sync.Mutex
+	busy map[uint64]struct{}
+}
+
+// handle calls the handler and reports whether it did. It doesn't when called from the
+// handler itself, so a handler logging through the hooked logger can't recurse, while
+// failures on other goroutines still reach it.
+func (r *errorReporter) handle(entry *logrus.Entry, err error) bool {
+	if r.handler == nil {
+		return false
+	}
+	id := goroutineID()
+	r.mu.Lock()
+	if _, ok := r.busy[id]; ok {
+		r.mu.Unlock()
+		return false
+	}
+	if r.busy == nil {
+		r.busy = make(map[uint64]struct{})
+	}
+	r.busy[id] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.busy, id)
+		r.mu.Unlock()
+	}()
+	r.handler(entry, err)
+	return true
+}
+
+// running reports whether the calling goroutine is running the handler, so what it logs
+// is quiet.
+func (r *errorReporter) running() bool {
+	if r.handler == nil {
+		return false
+	}
+	id := goroutineID()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.busy[id]
+	return ok
+}
+
+// goroutineID returns the id of the calling goroutine from the "goroutine N [...]" header
+// of its stack. The runtime doesn't export it, it only scopes the recursion guard.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
+
+// report calls the handler, or prints err to stderr when it can't.
+func (r *errorReporter) report(entry *logrus.Entry, err error) {
+	if !r.handle(entry, err) {
+		_, _ = fmt.Fprintf(os.Stderr, "log_hooks: %v\n", err)
+	}
+}
+
+// copyEntry copies the entry for a background send, logrus may reuse it after Fire.
+// Unlike entry.Dup it keeps the level, message and caller.
+func copyEntry(entry *logrus.Entry) *logrus.Entry {
+	dup := *entry
+	dup.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		dup.Data[k] = v
+	}
+	return &dup
+}
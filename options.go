@@ -0,0 +1,414 @@
+package log_hooks
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStartTLSNotSupported is returned when STARTTLS is requested but the server doesn't advertise it.
+var ErrStartTLSNotSupported = errors.New("smtp server doesn't support STARTTLS")
+
+// ErrConflictingTLSOptions is returned when both STARTTLS and implicit TLS are requested.
+var ErrConflictingTLSOptions = errors.New("STARTTLS and implicit TLS can't be used together")
+
+// ErrTLSClientAuth is returned when the server rejects the client certificate, or requires one
+// and none is configured, during the TLS handshake.
+var ErrTLSClientAuth = errors.New("smtp server rejected the tls client certificate")
+
+// ErrEmptyCredentials is returned when an authenticated hook is created without username or password.
+var ErrEmptyCredentials = errors.New("smtp username and password must not be empty")
+
+// ErrAuthNotSupported is returned when the server doesn't advertise the AUTH extension.
+var ErrAuthNotSupported = errors.New("smtp server doesn't support AUTH")
+
+// ErrInsecureTLSAuth is returned when certificate verification is disabled for a hook sending
+// its password in plaintext and WithStrictTLSAuth is set.
+var ErrInsecureTLSAuth = errors.New("plaintext smtp auth with InsecureSkipVerify exposes the password")
+
+// SetupOption configures UsefulSetupLogrus.
+type SetupOption func(*setupOptions)
+
+type setupOptions struct {
+	mailOptions []MailOption
+	username    string
+	password    string
+	mailLevel   string
+	stderrLevel string
+	dedupStore  *DedupStore
+	dedupField  string
+	noDedup     bool
+}
+
+// WithMailOptions passes options to the mail hook created by UsefulSetupLogrus.
+func WithMailOptions(opts ...MailOption) SetupOption {
+	return func(o *setupOptions) {
+		o.mailOptions = append(o.mailOptions, opts...)
+	}
+}
+
+// WithMailAuth makes UsefulSetupLogrus use an authenticated mail hook.
+func WithMailAuth(username string, password string) SetupOption {
+	return func(o *setupOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithMailLevel sets the least severe level UsefulSetupLogrus sends emails for [panic|fatal|error|warn|info|debug|trace].
+func WithMailLevel(level string) SetupOption {
+	return func(o *setupOptions) {
+		o.mailLevel = level
+	}
+}
+
+// WithStderrLevel sets the least severe level UsefulSetupLogrus also prints to stderr [panic|fatal|error|warn|info|debug|trace].
+func WithStderrLevel(level string) SetupOption {
+	return func(o *setupOptions) {
+		o.stderrLevel = level
+	}
+}
+
+// WithMailDedupStore makes the mail hook of UsefulSetupLogrus share store with other hooks,
+// see WithDedupStore. By default every call creates a hook with its own store.
+func WithMailDedupStore(store *DedupStore) SetupOption {
+	return func(o *setupOptions) {
+		o.dedupStore = store
+	}
+}
+
+// WithMailDedupField makes the mail hook of UsefulSetupLogrus deduplicate on the field,
+// see WithDedupField.
+func WithMailDedupField(field string) SetupOption {
+	return func(o *setupOptions) {
+		o.dedupField = field
+	}
+}
+
+// WithoutMailDedup makes the mail hook of UsefulSetupLogrus send every entry, see WithoutDedup.
+func WithoutMailDedup() SetupOption {
+	return func(o *setupOptions) {
+		o.noDedup = true
+	}
+}
+
+// MailOption configures optional behaviour of the mail hooks.
+type MailOption func(*mailOptions) error
+
+type mailOptions struct {
+	startTLS    bool
+	implicitTLS bool
+	tlsConfig   *tls.Config
+	// strictTLSAuth turns the InsecureSkipVerify and plaintext auth warning into an error.
+	strictTLSAuth bool
+
+	authMechanism AuthMechanism
+	tokenSource   func() (string, error)
+
+	allowInsecureAuth bool
+
+	cc      []string
+	bcc     []string
+	replyTo []string
+
+	subjectTemplate  *template.Template
+	subjectText      string
+	templateFuncs    template.FuncMap
+	maxSubjectLength int
+	bodyTemplate     *template.Template
+	bodyFormat       bodyFormat
+	stack            stackPolicy
+
+	noGoroutineDump              bool
+	goroutineDumpSize            int
+	goroutineDumpAttachThreshold int
+
+	maxFieldSize   int
+	timeLayout     string
+	timeLocation   *time.Location
+	timeNames      *TimeNames
+	history        *HistoryHook
+	maxBodySize    int
+	maxMessageSize int
+
+	attach          bool
+	attachThreshold int
+
+	poolSize        int
+	poolIdleTimeout time.Duration
+
+	async             bool
+	queueSize         int
+	queuePolicy       QueuePolicy
+	queueBlockTimeout time.Duration
+
+	digestWindow time.Duration
+
+	sendTimeout  time.Duration
+	closeTimeout time.Duration
+	retry        RetryPolicy
+
+	trace       bool
+	traceWriter io.Writer
+
+	dialer ContextDialer
+	proxy  *url.URL
+
+	redactedFields   []string
+	messageRedaction *regexp.Regexp
+
+	environment string
+
+	dedupStore      *DedupStore
+	recipientWindow time.Duration
+	messageWindow   time.Duration
+	maxPerWindow    int
+	windowsSet      bool
+	burst           int
+	refill          time.Duration
+	levelWindows    map[logrus.Level]time.Duration
+	rateLimitBypass []logrus.Level
+	bypassLevels    []logrus.Level
+	summary         bool
+	onSuppressed    func(entry *logrus.Entry, reason SuppressReason)
+	backoffMax      time.Duration
+	backoffCoolOff  time.Duration
+	dedupKey        KeyFunc
+	normalizeRules  []NormalizeRule
+	noDedup         bool
+
+	suppressionStore SuppressionStore
+
+	spoolDir      string
+	spoolMaxBytes int64
+	spoolMaxFiles int
+	spoolInterval time.Duration
+
+	// fieldPatterns is nil without a field filter.
+	fieldPatterns []string
+	includeFields bool
+
+	fallbacks []Endpoint
+
+	levelRecipients map[logrus.Level][]string
+	recipientFunc   RecipientFunc
+
+	dryRun io.Writer
+
+	levels []logrus.Level
+
+	localName string
+
+	skipConnectivityCheck bool
+	// transport replaces SMTP, see WithSender.
+	transport Sender
+
+	expvarName string
+
+	onError ErrorHandler
+
+	dkim *dkimSigner
+
+	priorities map[logrus.Level]Priority
+
+	headers         []headerField
+	headerOverrides []headerField
+}
+
+// WithStartTLS upgrades the SMTP connection with STARTTLS after EHLO.
+// A nil config means default settings. ServerName defaults to the hook host.
+func WithStartTLS(config *tls.Config) MailOption {
+	return func(o *mailOptions) error {
+		o.startTLS = true
+		if config != nil {
+			o.tlsConfig = config.Clone()
+		}
+		return nil
+	}
+}
+
+// WithImplicitTLS connects to the server over TLS from the start (SMTPS, usually port 465).
+// A nil config means default settings. ServerName defaults to the hook host.
+func WithImplicitTLS(config *tls.Config) MailOption {
+	return func(o *mailOptions) error {
+		o.implicitTLS = true
+		if config != nil {
+			o.tlsConfig = config.Clone()
+		}
+		return nil
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for STARTTLS and implicit TLS, e.g. to set
+// ServerName independently of the dialed host, pin RootCAs or set InsecureSkipVerify in labs.
+// Certificates are presented to servers requiring client authentication.
+// ServerName defaults to the hook host.
+func WithTLSConfig(config *tls.Config) MailOption {
+	return func(o *mailOptions) error {
+		if config == nil {
+			return errors.New("tls config must not be nil")
+		}
+		o.tlsConfig = config.Clone()
+		return nil
+	}
+}
+
+// WithStrictTLSAuth fails hook creation with ErrInsecureTLSAuth instead of printing a warning
+// when InsecureSkipVerify is combined with PLAIN or LOGIN authentication.
+func WithStrictTLSAuth() MailOption {
+	return func(o *mailOptions) error {
+		o.strictTLSAuth = true
+		return nil
+	}
+}
+
+// WithCc adds addresses to the Cc header and the envelope.
+func WithCc(addresses ...string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkAddresses("cc", addresses); err != nil {
+			return err
+		}
+		o.cc = append(o.cc, addresses...)
+		return nil
+	}
+}
+
+// WithBcc adds addresses to the envelope only, they never appear in the headers.
+func WithBcc(addresses ...string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkAddresses("bcc", addresses); err != nil {
+			return err
+		}
+		o.bcc = append(o.bcc, addresses...)
+		return nil
+	}
+}
+
+// WithReplyTo sets the Reply-To header, so replies to a no-reply sender reach a shared inbox.
+func WithReplyTo(addresses ...string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkAddresses("reply-to", addresses); err != nil {
+			return err
+		}
+		o.replyTo = append(o.replyTo, addresses...)
+		return nil
+	}
+}
+
+func checkAddresses(kind string, addresses []string) error {
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", kind, address, err)
+		}
+	}
+	return nil
+}
+
+// WithLocalName sets the name sent with EHLO/HELO, by default the machine hostname.
+func WithLocalName(name string) MailOption {
+	return func(o *mailOptions) error {
+		if !validLocalName(name) {
+			return fmt.Errorf("invalid local name %q", name)
+		}
+		o.localName = name
+		return nil
+	}
+}
+
+// WithoutConnectivityCheck skips dialing the server when the hook is created, for relays
+// that are only reachable at runtime. Only the addresses are validated, connectivity
+// problems are returned by the first Fire.
+func WithoutConnectivityCheck() MailOption {
+	return func(o *mailOptions) error {
+		o.skipConnectivityCheck = true
+		return nil
+	}
+}
+
+func validLocalName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+func newMailOptions(host string, opts []MailOption) (mailOptions, error) {
+	var o mailOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, err
+		}
+	}
+
+	if o.startTLS && o.implicitTLS {
+		return o, ErrConflictingTLSOptions
+	}
+
+	if err := o.parseTemplates(); err != nil {
+		return o, fmt.Errorf("invalid template: %w", err)
+	}
+
+	if o.localName == "" {
+		o.localName = "localhost"
+		if hostname, err := os.Hostname(); err == nil && validLocalName(hostname) {
+			o.localName = hostname
+		}
+	}
+
+	if o.sendTimeout == 0 {
+		o.sendTimeout = DefaultSendTimeout
+	}
+
+	if o.closeTimeout == 0 {
+		o.closeTimeout = DefaultCloseTimeout
+	}
+
+	if !o.windowsSet {
+		o.recipientWindow = DefaultRecipientWindow
+		o.messageWindow = DefaultMessageWindow
+	}
+	if o.refill > 0 {
+		o.recipientWindow = o.refill
+	}
+	if o.burst == 0 {
+		o.burst = 1
+	}
+	if o.maxPerWindow == 0 {
+		o.maxPerWindow = 1
+	}
+	if o.bypassLevels == nil {
+		o.bypassLevels = DefaultBypassLevels
+	}
+
+	if o.spoolInterval == 0 {
+		o.spoolInterval = DefaultSpoolRetryInterval
+	}
+
+	if o.dialer == nil {
+		o.dialer = &net.Dialer{Timeout: o.sendTimeout}
+	}
+	if o.proxy != nil {
+		o.dialer = &proxyDialer{proxy: o.proxy, forward: o.dialer}
+	}
+
+	if o.tlsConfig == nil {
+		o.tlsConfig = &tls.Config{}
+	}
+	return o, nil
+}
+
+func startTLS(client *smtp.Client, config *tls.Config) error {
+	if ok, _ := client.Extension("STARTTLS"); !ok {
+		return ErrStartTLSNotSupported
+	}
+	return client.StartTLS(config)
+}
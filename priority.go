@@ -0,0 +1,67 @@
+package log_hooks
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Priority is the importance of an email, sent as the X-Priority and Importance headers.
+type Priority int
+
+const (
+	// PriorityNone sends no priority headers.
+	PriorityNone Priority = iota
+	// PriorityHigh is shown with a red exclamation mark by most clients.
+	PriorityHigh
+	// PriorityNormal is the priority of ordinary emails.
+	PriorityNormal
+	// PriorityLow is shown with a blue arrow by most clients.
+	PriorityLow
+)
+
+// defaultPriorities maps levels to priorities, levels less severe than warn are low too.
+var defaultPriorities = map[logrus.Level]Priority{
+	logrus.PanicLevel: PriorityHigh,
+	logrus.FatalLevel: PriorityHigh,
+	logrus.ErrorLevel: PriorityNormal,
+	logrus.WarnLevel:  PriorityLow,
+}
+
+// WithPriority sets the priority of emails for the level, PriorityNone omits the headers.
+// By default panic and fatal are high, error is normal and the others are low.
+func WithPriority(level logrus.Level, priority Priority) MailOption {
+	return func(o *mailOptions) error {
+		if priority < PriorityNone || priority > PriorityLow {
+			return fmt.Errorf("unknown priority %d", priority)
+		}
+		if o.priorities == nil {
+			o.priorities = make(map[logrus.Level]Priority)
+		}
+		o.priorities[level] = priority
+		return nil
+	}
+}
+
+func (m *mailer) priority(level logrus.Level) Priority {
+	if priority, ok := m.options.priorities[level]; ok {
+		return priority
+	}
+	if priority, ok := defaultPriorities[level]; ok {
+		return priority
+	}
+	return PriorityLow
+}
+
+// priorityHeaders returns the X-Priority and Importance fields for the level.
+func (m *mailer) priorityHeaders(level logrus.Level) []headerField {
+	switch m.priority(level) {
+	case PriorityHigh:
+		return []headerField{{"X-Priority", "1 (Highest)"}, {"Importance", "high"}}
+	case PriorityNormal:
+		return []headerField{{"X-Priority", "3 (Normal)"}, {"Importance", "normal"}}
+	case PriorityLow:
+		return []headerField{{"X-Priority", "5 (Lowest)"}, {"Importance", "low"}}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package log_hooks
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestPriorityHeaders(t *testing.T) {
+	hook := newDryRunHook(t, WithoutDedup())
+	for level, want := range map[logrus.Level][2]string{
+		logrus.PanicLevel: {"1 (Highest)", "high"},
+		logrus.FatalLevel: {"1 (Highest)", "high"},
+		logrus.ErrorLevel: {"3 (Normal)", "normal"},
+		logrus.WarnLevel:  {"5 (Lowest)", "low"},
+		logrus.InfoLevel:  {"5 (Lowest)", "low"},
+	} {
+		msg := fireMessage(t, hook, testEntry(level, "priority"))
+		got := [2]string{msg.Header.Get("X-Priority"), msg.Header.Get("Importance")}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", level, got, want)
+		}
+	}
+}
+
+func TestPriorityOverride(t *testing.T) {
+	hook := newDryRunHook(t, WithoutDedup(), WithPriority(logrus.WarnLevel, PriorityHigh), WithPriority(logrus.ErrorLevel, PriorityNone))
+
+	msg := fireMessage(t, hook, testEntry(logrus.WarnLevel, "promoted"))
+	if got := msg.Header.Get("X-Priority"); got != "1 (Highest)" {
+		t.Fatalf("warn: got X-Priority %q", got)
+	}
+	msg = fireMessage(t, hook, testEntry(logrus.ErrorLevel, "no priority"))
+	if _, ok := msg.Header["X-Priority"]; ok {
+		t.Fatalf("error: got X-Priority %q, want none", msg.Header.Get("X-Priority"))
+	}
+	if _, ok := msg.Header["Importance"]; ok {
+		t.Fatalf("error: got Importance %q, want none", msg.Header.Get("Importance"))
+	}
+
+	if err := WithPriority(logrus.ErrorLevel, PriorityLow+1)(&mailOptions{}); err == nil {
+		t.Fatal("unknown priority accepted")
+	}
+}
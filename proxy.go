@@ -0,0 +1,266 @@
+package log_hooks
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContextDialer opens the connections to the SMTP server, *net.Dialer implements it.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network string, addr string) (net.Conn, error)
+}
+
+// WithDialer opens the connections to the SMTP server, including the connectivity check,
+// with dialer. Implicit TLS is negotiated over the returned connection.
+func WithDialer(dialer ContextDialer) MailOption {
+	return func(o *mailOptions) error {
+		if dialer == nil {
+			return errors.New("dialer must not be nil")
+		}
+		o.dialer = dialer
+		return nil
+	}
+}
+
+// WithProxy reaches the SMTP server through a proxy given as socks5://[user:password@]host:port
+// or http://[user:password@]host:port for HTTP CONNECT. The proxy is dialed with the dialer
+// set by WithDialer, if any. Failures of the proxy are returned as *ProxyError.
+func WithProxy(proxyURL string) MailOption {
+	return func(o *mailOptions) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url: %w", err)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Port() == "" {
+			return fmt.Errorf("proxy url %q has no port", proxyURL)
+		}
+		o.proxy = u
+		return nil
+	}
+}
+
+// ProxyError is returned when the proxy can't be reached or refuses the connection to the SMTP server.
+type ProxyError struct {
+	// Proxy is the proxy address.
+	Proxy string
+	Err   error
+}
+
+func (e *ProxyError) Error() string {
+	return "proxy " + e.Proxy + ": " + e.Err.Error()
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// proxyDialer tunnels connections through a SOCKS5 or HTTP CONNECT proxy.
+type proxyDialer struct {
+	proxy   *url.URL
+	forward ContextDialer
+}
+
+func (d *proxyDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, &ProxyError{Proxy: d.proxy.Host, Err: err}
+	}
+
+	// The handshake with the proxy is bounded by ctx like the dial.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if d.proxy.Scheme == "http" {
+		conn, err = d.connect(conn, addr)
+	} else {
+		err = d.socks5(conn, addr)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, &ProxyError{Proxy: d.proxy.Host, Err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect opens an HTTP CONNECT tunnel.
+func (d *proxyDialer) connect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return conn, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	// The greeting of the SMTP server may already be buffered.
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what the HTTP proxy response left buffered first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+var socks5Replies = []string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "ttl expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5 asks the proxy to connect to addr, as described in RFC 1928 and RFC 1929.
+func (d *proxyDialer) socks5(conn net.Conn, addr string) error {
+	host, strPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(strPort)
+	if err != nil {
+		return err
+	}
+
+	const noAuth, passwordAuth, noAcceptable = 0x00, 0x02, 0xff
+	methods := []byte{noAuth}
+	if d.proxy.User != nil {
+		methods = []byte{passwordAuth}
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("unexpected socks version %d", reply[0])
+	}
+	switch reply[1] {
+	case noAuth:
+	case passwordAuth:
+		if err := d.socks5Auth(conn); err != nil {
+			return err
+		}
+	case noAcceptable:
+		return errors.New("socks5 proxy requires authentication")
+	default:
+		return fmt.Errorf("unsupported socks5 auth method %d", reply[1])
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socks5Replies) {
+			return fmt.Errorf("socks5 connect %s: %s", addr, socks5Replies[header[1]])
+		}
+		return fmt.Errorf("socks5 connect %s: reply %d", addr, header[1])
+	}
+	// Skip the bound address.
+	var size int
+	switch header[3] {
+	case 1:
+		size = net.IPv4len
+	case 4:
+		size = net.IPv6len
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		size = int(length[0])
+	default:
+		return fmt.Errorf("unexpected socks5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
+
+func (d *proxyDialer) socks5Auth(conn net.Conn) error {
+	username := d.proxy.User.Username()
+	password, _ := d.proxy.User.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("socks5 username and password must not exceed 255 bytes")
+	}
+	req := []byte{1, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("socks5 proxy rejected the credentials")
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package log_hooks
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testProxy is a SOCKS5 or HTTP CONNECT proxy tunnelling every connection to target.
+type testProxy struct {
+	ln     net.Listener
+	target string
+	// username and password, when set, are required from the clients.
+	username string
+	password string
+	// reply is the SOCKS5 reply code or the HTTP status answering the connect request.
+	reply int
+	// coalesce sends the HTTP response with the greeting of the SMTP server in one write.
+	coalesce bool
+
+	mu        sync.Mutex
+	requested []string
+}
+
+func newTestProxy(t *testing.T, scheme string, target string, configure func(p *testProxy)) *testProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	p := &testProxy{ln: ln, target: target}
+	if configure != nil {
+		configure(p)
+	}
+	serve := p.socks5
+	if scheme == "http" {
+		serve = p.connect
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return p
+}
+
+// url returns the proxy URL with the credentials of the user.
+func (p *testProxy) url(scheme string, user string) string {
+	if user != "" {
+		user += "@"
+	}
+	return scheme + "://" + user + p.ln.Addr().String()
+}
+
+func (p *testProxy) request(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requested = append(p.requested, addr)
+}
+
+func (p *testProxy) requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requested...)
+}
+
+// socks5 serves a connection as described in RFC 1928 and RFC 1929.
+func (p *testProxy) socks5(conn net.Conn, r *bufio.Reader) {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	method := byte(0x00)
+	if p.password != "" {
+		method = 0x02
+	}
+	if !strings.Contains(string(methods), string(method)) {
+		_, _ = conn.Write([]byte{5, 0xff})
+		return
+	}
+	_, _ = conn.Write([]byte{5, method})
+	if method == 0x02 {
+		// The version of the subnegotiation precedes the username and the password.
+		if _, err := r.ReadByte(); err != nil {
+			return
+		}
+		username, err := readShortString(r)
+		if err != nil {
+			return
+		}
+		password, err := readShortString(r)
+		if err != nil {
+			return
+		}
+		if username != p.username || password != p.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	var host string
+	switch header[3] {
+	case 1, 4:
+		ip := make(net.IP, map[byte]int{1: net.IPv4len, 4: net.IPv6len}[header[3]])
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case 3:
+		name, err := readShortString(r)
+		if err != nil {
+			return
+		}
+		host = name
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return
+	}
+	p.request(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if p.reply != 0 {
+		_, _ = conn.Write([]byte{5, byte(p.reply), 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer func() { _ = target.Close() }()
+	// The bound address is given as a domain name, which the client has to skip too.
+	bound := append([]byte{5, 0, 0, 3, byte(len("proxy.test"))}, "proxy.test"...)
+	_, _ = conn.Write(binary.BigEndian.AppendUint16(bound, 1080))
+	tunnel(conn, r, target)
+}
+
+// readShortString reads a string preceded by its length in a byte.
+func readShortString(r *bufio.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
+
+// connect serves an HTTP CONNECT request.
+func (p *testProxy) connect(conn net.Conn, r *bufio.Reader) {
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	p.request(req.Host)
+	status := p.reply
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if p.password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.username + ":" + p.password))
+		if req.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			status = http.StatusProxyAuthRequired
+		}
+	}
+	response := "HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n\r\n"
+	if status != http.StatusOK {
+		_, _ = io.WriteString(conn, response)
+		return
+	}
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer func() { _ = target.Close() }()
+	var greeting string
+	if p.coalesce {
+		if greeting, err = bufio.NewReader(target).ReadString('\n'); err != nil {
+			return
+		}
+	}
+	_, _ = io.WriteString(conn, response+greeting)
+	tunnel(conn, r, target)
+}
+
+// tunnel copies between the client and the target until either closes.
+func tunnel(conn net.Conn, r io.Reader, target net.Conn) {
+	go func() {
+		_, _ = io.Copy(target, r)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+// newProxyHook creates a hook sending to host through the proxy.
+func newProxyHook(t *testing.T, host string, port int, proxyURL string) *MailHook {
+	t.Helper()
+	hook, err := NewMailHook("app", host, port, "alerts@example.com", "ops@example.com", WithoutConnectivityCheck(), WithProxy(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = hook.Close() })
+	return hook
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	server := newTestServer(t, nil)
+	for _, test := range []struct {
+		host     string
+		password string
+		user     string
+	}{
+		{"mail.test", "", ""},
+		{"127.0.0.1", "", ""},
+		{"mail.test", "secret", "alerts:secret"},
+	} {
+		proxy := newTestProxy(t, "socks5", server.ln.Addr().String(), func(p *testProxy) {
+			p.username, p.password = "alerts", test.password
+		})
+		hook := newProxyHook(t, test.host, server.port(), proxy.url("socks5", test.user))
+		before := len(server.received())
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "through socks5")); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(server.received()); n != before+1 {
+			t.Fatalf("%s: got %d messages, want %d", test.host, n, before+1)
+		}
+		want := net.JoinHostPort(test.host, strconv.Itoa(server.port()))
+		if requests := proxy.requests(); len(requests) != 1 || requests[0] != want {
+			t.Fatalf("got connect requests %q, want %q", requests, want)
+		}
+	}
+}
+
+func TestSOCKS5ProxyErrors(t *testing.T) {
+	server := newTestServer(t, nil)
+	for _, test := range []struct {
+		user  string
+		reply int
+		want  string
+	}{
+		{"", 0, "socks5 proxy requires authentication"},
+		{"alerts:wrong", 0, "socks5 proxy rejected the credentials"},
+		{"alerts:secret", 2, "connection not allowed by ruleset"},
+		{"alerts:secret", 5, "connection refused"},
+		{"alerts:secret", 42, "reply 42"},
+	} {
+		proxy := newTestProxy(t, "socks5", server.ln.Addr().String(), func(p *testProxy) {
+			p.username, p.password, p.reply = "alerts", "secret", test.reply
+		})
+		err := newProxyHook(t, "mail.test", server.port(), proxy.url("socks5", test.user)).Fire(testEntry(logrus.ErrorLevel, "refused"))
+		var proxyErr *ProxyError
+		if !errors.As(err, &proxyErr) || proxyErr.Proxy != proxy.ln.Addr().String() || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("got %v, want a ProxyError with %q", err, test.want)
+		}
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages through failed proxies", n)
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	server := newTestServer(t, nil)
+	// With coalesce the greeting of the SMTP server arrives with the response to CONNECT.
+	for _, coalesce := range []bool{false, true} {
+		proxy := newTestProxy(t, "http", server.ln.Addr().String(), func(p *testProxy) {
+			p.username, p.password, p.coalesce = "alerts", "secret", coalesce
+		})
+		hook := newProxyHook(t, "mail.test", server.port(), proxy.url("http", "alerts:secret"))
+		before := len(server.received())
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "through connect")); err != nil {
+			t.Fatalf("coalesce %v: %v", coalesce, err)
+		}
+		if n := len(server.received()); n != before+1 {
+			t.Fatalf("coalesce %v: got %d messages, want %d", coalesce, n, before+1)
+		}
+		want := "mail.test:" + strconv.Itoa(server.port())
+		if requests := proxy.requests(); len(requests) != 1 || requests[0] != want {
+			t.Fatalf("got connect requests %q, want %q", requests, want)
+		}
+	}
+}
+
+func TestHTTPConnectProxyErrors(t *testing.T) {
+	server := newTestServer(t, nil)
+	for _, test := range []struct {
+		user  string
+		reply int
+		want  string
+	}{
+		{"", 0, "407 Proxy Authentication Required"},
+		{"alerts:wrong", 0, "407 Proxy Authentication Required"},
+		{"alerts:secret", http.StatusForbidden, "403 Forbidden"},
+	} {
+		proxy := newTestProxy(t, "http", server.ln.Addr().String(), func(p *testProxy) {
+			p.username, p.password, p.reply = "alerts", "secret", test.reply
+		})
+		err := newProxyHook(t, "mail.test", server.port(), proxy.url("http", test.user)).Fire(testEntry(logrus.ErrorLevel, "refused"))
+		var proxyErr *ProxyError
+		if !errors.As(err, &proxyErr) || !strings.Contains(err.Error(), "CONNECT mail.test:") || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("got %v, want a ProxyError with %q", err, test.want)
+		}
+	}
+
+	// A proxy that can't be reached is a ProxyError too.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	_ = ln.Close()
+	err = newProxyHook(t, "mail.test", server.port(), "http://"+down).Fire(testEntry(logrus.ErrorLevel, "down"))
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) || proxyErr.Proxy != down {
+		t.Fatalf("got %v, want a ProxyError", err)
+	}
+}
+
+func TestWithProxyValidation(t *testing.T) {
+	for _, proxyURL := range []string{"ftp://127.0.0.1:21", "socks5://127.0.0.1", "http://%zz"} {
+		if _, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+			WithoutConnectivityCheck(), WithProxy(proxyURL)); err == nil {
+			t.Fatalf("WithProxy(%q) succeeded", proxyURL)
+		}
+	}
+}
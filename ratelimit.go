@@ -0,0 +1,72 @@
+package log_hooks
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithTokenBucket rate limits each recipient set with a token bucket instead of one email per
+// recipient window: up to capacity emails go out at once, one more every refill, so a burst of
+// distinct errors in an incident isn't held back while sustained volume stays capped.
+// The default, a capacity of 1 refilled every recipient window, is WithSuppressionWindows.
+// Duplicates of an error are still suppressed by the message window.
+func WithTokenBucket(capacity int, refill time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if capacity <= 0 || refill <= 0 {
+			return fmt.Errorf("token bucket capacity and refill must be positive, got %d and %s", capacity, refill)
+		}
+		o.burst = capacity
+		o.refill = refill
+		return nil
+	}
+}
+
+// WithMaxPerWindow lets up to n emails for the same error go out in its message window before
+// the rest of the window is suppressed, so the first email of an error isn't the only one in a
+// busy inbox. The window starts with the first email and the count with it. The default is 1.
+// A SuppressionStore decides on the duplicates by itself.
+func WithMaxPerWindow(n int) MailOption {
+	return func(o *mailOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("emails per window must be positive, got %d", n)
+		}
+		o.maxPerWindow = n
+		return nil
+	}
+}
+
+// windowCount is the message window of an error: when its first email was sent and how many
+// were sent since, see WithMaxPerWindow.
+type windowCount struct {
+	start time.Time
+	sends int
+}
+
+// bucket is the token bucket of a recipient set: burst sends at once, refilled one every
+// interval. Its state is a single time, the slot: the last send moved ahead one interval for
+// each send that took a token before it was refilled. The bucket is empty while the slot plus
+// one interval is more than burst-1 intervals ahead of now.
+type bucket struct {
+	every time.Duration
+	burst int
+}
+
+// blockedUntil returns when a bucket with slot has a token again, zero when it has one now.
+func (b bucket) blockedUntil(slot time.Time, now time.Time) time.Time {
+	if b.every <= 0 || slot.IsZero() {
+		return time.Time{}
+	}
+	until := slot.Add(-time.Duration(b.burst-1) * b.every).Add(b.every)
+	if until.After(now) {
+		return until
+	}
+	return time.Time{}
+}
+
+// take returns the slot after a send at now.
+func (b bucket) take(slot time.Time, now time.Time) time.Time {
+	if next := slot.Add(b.every); !slot.IsZero() && next.After(now) {
+		return next
+	}
+	return now
+}
@@ -0,0 +1,94 @@
+package log_hooks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTokenBucket(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))), WithTokenBucket(3, time.Minute))
+	fired := 0
+	// burst fires n distinct errors and returns how many were sent.
+	burst := func(n int) int {
+		t.Helper()
+		sent := 0
+		for range n {
+			fired++
+			sent += countSent(t, hook, testEntry(logrus.ErrorLevel, fmt.Sprintf("error %d", fired)))
+		}
+		return sent
+	}
+
+	// A full bucket lets the burst out at once.
+	if n := burst(4); n != 3 {
+		t.Fatalf("got %d emails of a burst of 4, want 3", n)
+	}
+	// One token comes back every minute.
+	clock.Advance(time.Minute - time.Nanosecond)
+	if n := burst(1); n != 0 {
+		t.Fatal("sent before the refill")
+	}
+	clock.Advance(time.Nanosecond)
+	if n := burst(2); n != 1 {
+		t.Fatalf("got %d emails after a refill, want 1", n)
+	}
+	// An idle bucket doesn't fill beyond its capacity.
+	clock.Advance(10 * time.Minute)
+	if n := burst(5); n != 3 {
+		t.Fatalf("got %d emails after idling, want 3", n)
+	}
+	// Duplicates are still suppressed by the message window, with tokens to spare.
+	clock.Advance(time.Hour)
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "repeated"), testEntry(logrus.ErrorLevel, "repeated")); n != 1 {
+		t.Fatalf("got %d emails of a duplicate, want 1", n)
+	}
+}
+
+func TestMaxPerWindow(t *testing.T) {
+	clock := newTestClock()
+	hook := newDryRunHook(t, WithDedupStore(NewDedupStore(WithClock(clock.Now))),
+		WithSuppressionWindows(0, 10*time.Minute), WithMaxPerWindow(3))
+	send := func(n int) int {
+		t.Helper()
+		entries := make([]*logrus.Entry, n)
+		for i := range entries {
+			entries[i] = testEntry(logrus.ErrorLevel, "disk full")
+		}
+		return countSent(t, hook, entries...)
+	}
+
+	if n := send(2); n != 2 {
+		t.Fatalf("got %d emails, want 2", n)
+	}
+	clock.Advance(5 * time.Minute)
+	if n := send(2); n != 1 {
+		t.Fatalf("got %d emails later in the window, want 1", n)
+	}
+	// The window ends 10 minutes after its first email, the count starts over with the next.
+	clock.Advance(5*time.Minute - time.Nanosecond)
+	if n := send(1); n != 0 {
+		t.Fatal("sent before the window ended")
+	}
+	clock.Advance(time.Nanosecond)
+	if n := send(4); n != 3 {
+		t.Fatalf("got %d emails in the next window, want 3", n)
+	}
+
+	// Other errors have windows of their own.
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "queue stuck")); n != 1 {
+		t.Fatal("another error suppressed")
+	}
+}
+
+func TestRateLimitOptions(t *testing.T) {
+	for _, opt := range []MailOption{WithTokenBucket(0, time.Minute), WithTokenBucket(1, 0), WithMaxPerWindow(0)} {
+		if _, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+			WithoutConnectivityCheck(), opt); err == nil {
+			t.Fatal("invalid rate limit accepted")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces redacted values in emails.
+const Redacted = "[REDACTED]"
+
+// WithRedactedFields replaces the values of the fields matching any of patterns with Redacted,
+// in the body and in the subject template. Patterns are case-insensitive globs like "*_token",
+// keys of nested maps are matched too.
+func WithRedactedFields(patterns ...string) MailOption {
+	return func(o *mailOptions) error {
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(pattern)
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid field pattern %q: %w", pattern, err)
+			}
+			o.redactedFields = append(o.redactedFields, pattern)
+		}
+		return nil
+	}
+}
+
+// WithMessageRedaction replaces the matches of re in the message with Redacted.
+func WithMessageRedaction(re *regexp.Regexp) MailOption {
+	return func(o *mailOptions) error {
+		if re == nil {
+			return errors.New("message redaction regexp must not be nil")
+		}
+		o.messageRedaction = re
+		return nil
+	}
+}
+
+// redactMessage applies the message redaction, if any.
+func (m *mailer) redactMessage(message string) string {
+	if m.options.messageRedaction == nil {
+		return message
+	}
+	return m.options.messageRedaction.ReplaceAllLiteralString(message, Redacted)
+}
+
+// redactFields returns a copy of fields with the redacted values replaced.
+func (m *mailer) redactFields(fields logrus.Fields) logrus.Fields {
+	if len(m.options.redactedFields) == 0 {
+		return fields
+	}
+	return logrus.Fields(m.redactMap(fields))
+}
+
+func (m *mailer) redactMap(fields map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if m.redactsField(key) {
+			redacted[key] = Redacted
+		} else {
+			redacted[key] = m.redactValue(value)
+		}
+	}
+	return redacted
+}
+
+func (m *mailer) redactValue(value interface{}) interface{} {
+	switch nested := value.(type) {
+	case logrus.Fields:
+		return logrus.Fields(m.redactMap(nested))
+	case map[string]interface{}:
+		return m.redactMap(nested)
+	case map[string]string:
+		redacted := make(map[string]string, len(nested))
+		for key, v := range nested {
+			if m.redactsField(key) {
+				v = Redacted
+			}
+			redacted[key] = v
+		}
+		return redacted
+	}
+	return value
+}
+
+func (m *mailer) redactsField(key string) bool {
+	return matchesField(m.options.redactedFields, key)
+}
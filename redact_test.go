@@ -0,0 +1,66 @@
+package log_hooks
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedactedFields(t *testing.T) {
+	hook := newDryRunHook(t, WithRedactedFields("PASSWORD", "*_token"),
+		WithSubjectTemplate("{{.Fields.password}} {{.Fields.user}} {{.Fields.Api_Token}}"))
+	entry := testEntry(logrus.ErrorLevel, "login failed")
+	request := map[string]interface{}{
+		"Auth_Token": "tok-nested",
+		"path":       "/login",
+		"headers":    map[string]string{"X_Session_Token": "tok-header", "Accept": "application/json"},
+		"db":         logrus.Fields{"Password": "pw-fields", "host": "db.internal"},
+	}
+	entry.Data = logrus.Fields{"password": "hunter2", "Api_Token": "tok-top", "user": "bob", "request": request}
+
+	msg := fireMessage(t, hook, entry)
+	if subject := decodedSubject(t, msg); subject != "[REDACTED] bob [REDACTED]" {
+		t.Fatalf("got subject %q", subject)
+	}
+	body := decodedBody(t, msg)
+	for _, secret := range []string{"hunter2", "tok-top", "tok-nested", "tok-header", "pw-fields"} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("body holds %q:\n%s", secret, body)
+		}
+	}
+	for _, kept := range []string{"bob", "/login", "application/json", "db.internal", Redacted} {
+		if !strings.Contains(body, kept) {
+			t.Fatalf("body doesn't hold %q:\n%s", kept, body)
+		}
+	}
+
+	// The entry passed on to the other hooks keeps its values.
+	if entry.Data["password"] != "hunter2" || request["Auth_Token"] != "tok-nested" ||
+		request["headers"].(map[string]string)["X_Session_Token"] != "tok-header" {
+		t.Fatalf("the entry was redacted: %v", entry.Data)
+	}
+}
+
+func TestMessageRedaction(t *testing.T) {
+	hook := newDryRunHook(t, WithMessageRedaction(regexp.MustCompile(`key=\w+`)), WithSubjectTemplate("{{.Message}}"))
+
+	msg := fireMessage(t, hook, testEntry(logrus.ErrorLevel, "login with key=s3cr3t failed, key=0ther"))
+	want := "login with [REDACTED] failed, [REDACTED]"
+	if subject := decodedSubject(t, msg); subject != want {
+		t.Fatalf("got subject %q, want %q", subject, want)
+	}
+	if body := decodedBody(t, msg); !strings.Contains(body, "MESSAGE: "+want) || strings.Contains(body, "s3cr3t") {
+		t.Fatalf("got body:\n%s", body)
+	}
+}
+
+func TestRedactionOptions(t *testing.T) {
+	for _, opt := range []MailOption{WithRedactedFields("token", "[a-"), WithMessageRedaction(nil)} {
+		if _, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com",
+			WithoutConnectivityCheck(), opt); err == nil {
+			t.Fatal("invalid redaction accepted")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package log_hooks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisStore is a SuppressionStore on Redis. TryAcquire claims the key with SET NX and a TTL
+// of the window, so it expires by itself, Release deletes it. Services sharing a server need
+// their own Prefix.
+type RedisStore struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password authenticate with AUTH when Password is set.
+	Username string
+	Password string
+	DB       int
+	// Prefix is prepended to the keys, "log_hooks:" when empty.
+	Prefix string
+	// TLSConfig enables TLS.
+	TLSConfig *tls.Config
+	// Dialer opens the connection, a net.Dialer when nil.
+	Dialer ContextDialer
+
+	conn storeConn
+}
+
+// RedisError is an error reply of the Redis server.
+type RedisError struct {
+	Message string
+}
+
+func (e *RedisError) Error() string {
+	return "redis: " + e.Message
+}
+
+func (s *RedisStore) TryAcquire(ctx context.Context, key string, window time.Duration) (bool, error) {
+	reply, err := s.do(ctx, "SET", s.key(key), "1", "NX", "PX", ttl(window))
+	if err != nil {
+		return false, err
+	}
+	// A nil reply means another replica holds the key.
+	return reply != nil, nil
+}
+
+func (s *RedisStore) MarkSent(ctx context.Context, key string, window time.Duration) error {
+	_, err := s.do(ctx, "SET", s.key(key), "1", "PX", ttl(window))
+	return err
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", s.key(key))
+	return err
+}
+
+// ttl formats the window in milliseconds for PX, which rejects zero.
+func ttl(window time.Duration) string {
+	return strconv.FormatInt(max(window.Milliseconds(), 1), 10)
+}
+
+// Close closes the connection to the server.
+func (s *RedisStore) Close() error {
+	return s.conn.close()
+}
+
+// key hashes the key, so long messages make short keys.
+func (s *RedisStore) key(key string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "log_hooks:"
+	}
+	return hashedKey(prefix, key)
+}
+
+// do runs a command, reconnecting when the connection broke. A nil reply is returned as nil.
+func (s *RedisStore) do(ctx context.Context, args ...string) (*string, error) {
+	if err := s.conn.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.conn.release()
+
+	if s.conn.conn == nil {
+		if err := s.conn.open(ctx, s.Dialer, s.Addr, s.TLSConfig); err != nil {
+			return nil, err
+		}
+		if err := s.login(ctx); err != nil {
+			_ = s.conn.drop()
+			return nil, err
+		}
+	}
+	reply, err := s.command(ctx, args...)
+	var redisErr *RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		_ = s.conn.drop()
+	}
+	return reply, err
+}
+
+// login authenticates and selects the database.
+func (s *RedisStore) login(ctx context.Context) error {
+	if s.Password != "" {
+		args := []string{"AUTH", s.Password}
+		if s.Username != "" {
+			args = []string{"AUTH", s.Username, s.Password}
+		}
+		if _, err := s.command(ctx, args...); err != nil {
+			return err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := s.command(ctx, "SELECT", strconv.Itoa(s.DB)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command writes the command in RESP and reads the reply.
+func (s *RedisStore) command(ctx context.Context, args ...string) (*string, error) {
+	defer s.conn.bound(ctx)()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return s.reply()
+}
+
+// reply reads a simple string, error, integer or bulk string reply.
+func (s *RedisStore) reply() (*string, error) {
+	line, err := s.conn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	value := line[1:]
+	switch line[0] {
+	case '+', ':':
+		return &value, nil
+	case '-':
+		return nil, &RedisError{Message: value}
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", value)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(s.conn.r, data); err != nil {
+			return nil, err
+		}
+		bulk := string(data[:size])
+		return &bulk, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,316 @@
+package log_hooks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testRedis is an in-process Redis speaking RESP for AUTH, SELECT, SET and DEL.
+type testRedis struct {
+	ln net.Listener
+	// username and password, when set, are required by AUTH before other commands.
+	username string
+	password string
+
+	mu       sync.Mutex
+	items    map[string]time.Time
+	commands []string
+	conns    int
+}
+
+func newTestRedis(t *testing.T, username, password string) *testRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &testRedis{ln: ln, username: username, password: password, items: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testRedis) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		s.mu.Unlock()
+
+		var reply string
+		switch {
+		case strings.EqualFold(args[0], "AUTH"):
+			reply = s.auth(args[1:])
+			authenticated = reply == "+OK"
+		case !authenticated:
+			reply = "-NOAUTH Authentication required."
+		default:
+			reply = s.handle(args)
+		}
+		if _, err := io.WriteString(conn, reply+"\r\n"); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+	if err != nil || n < 1 {
+		return nil, errors.New("not an array")
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(line), "$"))
+		if err != nil {
+			return nil, errors.New("not a bulk string")
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (s *testRedis) auth(args []string) string {
+	username, password := "default", ""
+	switch len(args) {
+	case 1:
+		password = args[0]
+	case 2:
+		username, password = args[0], args[1]
+	default:
+		return "-ERR wrong number of arguments for 'auth' command"
+	}
+	want := s.username
+	if want == "" {
+		want = "default"
+	}
+	if username != want || password != s.password {
+		return "-WRONGPASS invalid username-password pair or user is disabled."
+	}
+	return "+OK"
+}
+
+func (s *testRedis) handle(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "SELECT":
+		if db, err := strconv.Atoi(args[1]); err != nil || db > 15 {
+			return "-ERR DB index is out of range"
+		}
+		return "+OK"
+	case "SET":
+		var nx bool
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, err := strconv.Atoi(args[i+1])
+				if err != nil || ms <= 0 {
+					return "-ERR invalid expire time in 'set' command"
+				}
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		if expires, ok := s.items[args[1]]; ok && nx && time.Now().Before(expires) {
+			return "$-1"
+		}
+		s.items[args[1]] = time.Now().Add(ttl)
+		return "+OK"
+	case "DEL":
+		if _, ok := s.items[args[1]]; !ok {
+			return ":0"
+		}
+		delete(s.items, args[1])
+		return ":1"
+	}
+	return "-ERR unknown command '" + args[0] + "'"
+}
+
+func (s *testRedis) commandLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testRedis) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func TestRedisStore(t *testing.T) {
+	server := newTestRedis(t, "", "")
+	store := &RedisStore{Addr: server.ln.Addr().String()}
+	defer func() { _ = store.Close() }()
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		ok, err := store.TryAcquire(ctx, "disk full", 1500*time.Millisecond)
+		if err != nil || ok != want {
+			t.Fatalf("acquire %d: got %v, %v, want %v", i+1, ok, err, want)
+		}
+	}
+	if err := store.MarkSent(ctx, "disk full", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(ctx, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	// Releasing an expired claim is fine too.
+	if err := store.Release(ctx, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.TryAcquire(ctx, "disk full", 0); err != nil || !ok {
+		t.Fatalf("acquire after release: got %v, %v", ok, err)
+	}
+
+	key := store.key("disk full")
+	want := []string{"SET " + key + " 1 NX PX 1500", "SET " + key + " 1 NX PX 1500", "SET " + key + " 1 PX 600000",
+		"DEL " + key, "DEL " + key, "SET " + key + " 1 NX PX 1"}
+	commands := server.commandLog()
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got commands %q, want %q", commands, want)
+	}
+	if n := server.connCount(); n != 1 {
+		t.Fatalf("got %d connections, want 1", n)
+	}
+}
+
+func TestRedisStoreLogin(t *testing.T) {
+	server := newTestRedis(t, "alerts", "secret")
+	store := &RedisStore{Addr: server.ln.Addr().String(), Username: "alerts", Password: "secret", DB: 2, Prefix: "billing:"}
+	defer func() { _ = store.Close() }()
+
+	if ok, err := store.TryAcquire(context.Background(), "disk full", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	commands := server.commandLog()
+	if len(commands) != 3 || commands[0] != "AUTH alerts secret" || commands[1] != "SELECT 2" || !strings.HasPrefix(commands[2], "SET billing:") {
+		t.Fatalf("got commands %q", commands)
+	}
+
+	// A rejected login drops the connection and is tried again with the next command.
+	wrong := &RedisStore{Addr: server.ln.Addr().String(), Password: "secret"}
+	defer func() { _ = wrong.Close() }()
+	for i := range 2 {
+		_, err := wrong.TryAcquire(context.Background(), "disk full", time.Minute)
+		var redisErr *RedisError
+		if !errors.As(err, &redisErr) || !strings.HasPrefix(redisErr.Message, "WRONGPASS") {
+			t.Fatalf("attempt %d: got %v, want WRONGPASS", i+1, err)
+		}
+	}
+	if n := server.connCount(); n != 3 {
+		t.Fatalf("got %d connections, want 3", n)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	server := newTestRedis(t, "", "")
+	store := &RedisStore{Addr: server.ln.Addr().String()}
+	defer func() { _ = store.Close() }()
+
+	_, err := store.do(context.Background(), "FAIL")
+	var redisErr *RedisError
+	if !errors.As(err, &redisErr) || err.Error() != "redis: ERR unknown command 'FAIL'" {
+		t.Fatalf("got %v, want a RedisError", err)
+	}
+	// An error reply keeps the connection.
+	if ok, err := store.TryAcquire(context.Background(), "k", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if n := server.connCount(); n != 1 {
+		t.Fatalf("got %d connections, want 1", n)
+	}
+
+	down := &RedisStore{Addr: "127.0.0.1:1"}
+	if _, err := down.TryAcquire(context.Background(), "k", time.Minute); err == nil {
+		t.Fatal("unreachable server acquired")
+	}
+}
+
+func TestRedisStoreHooks(t *testing.T) {
+	redis := newTestRedis(t, "", "")
+	server := newTestServer(t, nil)
+	newHook := func() *MailHook {
+		hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com", WithoutConnectivityCheck(),
+			WithSuppressionStore(&RedisStore{Addr: redis.ln.Addr().String()}), WithSuppressionWindows(0, time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hook
+	}
+
+	// Two replicas send the error once.
+	replicas := []*MailHook{newHook(), newHook()}
+	for _, hook := range replicas {
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "incident")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+
+	// A failed send deletes the claim, so the other replica sends.
+	server.rejectData.Store(1)
+	if err := replicas[0].Fire(testEntry(logrus.ErrorLevel, "lost")); err == nil {
+		t.Fatal("rejected send succeeded")
+	}
+	if err := replicas[1].Fire(testEntry(logrus.ErrorLevel, "lost")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 2 {
+		t.Fatalf("got %d messages, want 2", n)
+	}
+	var deleted int
+	for _, command := range redis.commandLog() {
+		if strings.HasPrefix(command, "DEL ") {
+			deleted++
+		}
+	}
+	if deleted != 1 {
+		t.Fatalf("got %d DEL commands, want 1", deleted)
+	}
+}
@@ -0,0 +1,95 @@
+package log_hooks
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+// storeConn is the single connection of a remote SuppressionStore, opened on first use and again
+// after it broke. Unlike a mutex, waiting for it respects the context.
+type storeConn struct {
+	once sync.Once
+	sem  chan struct{}
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// acquire waits for the connection, the caller calls release.
+func (c *storeConn) acquire(ctx context.Context) error {
+	c.once.Do(func() { c.sem = make(chan struct{}, 1) })
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *storeConn) release() {
+	<-c.sem
+}
+
+// open dials addr, with TLS when config is set. The caller holds the connection.
+func (c *storeConn) open(ctx context.Context, dialer ContextDialer, addr string, config *tls.Config) error {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		config = config.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	return nil
+}
+
+// bound makes the I/O on the connection give up when ctx is done, until the returned stop.
+func (c *storeConn) bound(ctx context.Context) (stop func() bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	}
+	return context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Now()) })
+}
+
+// drop closes a broken connection, the next command opens a new one.
+func (c *storeConn) drop() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// close closes the connection once it is free.
+func (c *storeConn) close() error {
+	if err := c.acquire(context.Background()); err != nil {
+		return err
+	}
+	defer c.release()
+	return c.drop()
+}
+
+// hashedKey prefixes the hash of the key, so long messages make short keys without spaces.
+func hashedKey(prefix string, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return prefix + hex.EncodeToString(sum[:])
+}
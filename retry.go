@@ -0,0 +1,148 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy describes how failed sends are retried. Only temporary failures
+// are retried: 4xx replies and network errors, never 5xx rejections.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry.
+	InitialDelay time.Duration
+	// Multiplier grows the delay after every retry.
+	Multiplier float64
+	// Jitter is the fraction of the delay, from 0 to 1, randomly added or subtracted.
+	Jitter float64
+}
+
+// WithRetry retries temporary send failures with exponential backoff.
+// In async mode the retries happen in the background worker.
+func WithRetry(policy RetryPolicy) MailOption {
+	return func(o *mailOptions) error {
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("retry max attempts must be at least 1, got %d", policy.MaxAttempts)
+		}
+		if policy.InitialDelay < 0 {
+			return fmt.Errorf("retry initial delay must not be negative, got %s", policy.InitialDelay)
+		}
+		if policy.Multiplier < 1 {
+			return fmt.Errorf("retry multiplier must be at least 1, got %g", policy.Multiplier)
+		}
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return fmt.Errorf("retry jitter must be between 0 and 1, got %g", policy.Jitter)
+		}
+		o.retry = policy
+		return nil
+	}
+}
+
+// outgoing is a rendered message waiting for delivery.
+type outgoing struct {
+	// entry is the logged entry, nil for digests.
+	entry *logrus.Entry
+	// quiet is set for entries logged from the error handler.
+	quiet   bool
+	message []byte
+	// recipients are the To addresses, cc and bcc are added to the envelope.
+	recipients []string
+	// sent is called once the message is delivered.
+	sent func()
+	// failed is called when the message is given up, not when it is spooled.
+	failed func()
+}
+
+// ErrSendCancelled is returned when the context of the entry is done before the email is sent.
+var ErrSendCancelled = errors.New("mail hook send cancelled")
+
+// deliver sends the message, retrying temporary failures until ctx is done.
+func (m *mailer) deliver(ctx context.Context, out outgoing) error {
+	policy := m.options.retry
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 1
+	}
+
+	delay := policy.InitialDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = m.send(ctx, out)
+		// A send that made it is delivered even if ctx ended right after.
+		var partial *partialDeliveryError
+		if err == nil || errors.As(err, &partial) {
+			m.stats.sent.Add(1)
+			if out.sent != nil {
+				out.sent()
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			m.giveUp(out)
+			return fmt.Errorf("%w: %w", ErrSendCancelled, ctx.Err())
+		}
+		if !isTemporary(err) {
+			m.giveUp(out)
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			if m.spool != nil {
+				if spoolErr := m.spool.store(out); spoolErr == nil {
+					m.stats.spooled.Add(1)
+					return spoolError(err)
+				}
+			}
+			m.giveUp(out)
+			return err
+		}
+
+		select {
+		case <-time.After(jitter(delay, policy.Jitter)):
+		case <-ctx.Done():
+			m.giveUp(out)
+			return fmt.Errorf("%w: %w", ErrSendCancelled, ctx.Err())
+		}
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+	}
+}
+
+// giveUp counts a message that won't be delivered.
+func (m *mailer) giveUp(out outgoing) {
+	m.stats.failed.Add(1)
+	if out.failed != nil {
+		out.failed()
+	}
+}
+
+func jitter(delay time.Duration, fraction float64) time.Duration {
+	if fraction == 0 || delay == 0 {
+		return delay
+	}
+	return delay + time.Duration((rand.Float64()*2-1)*fraction*float64(delay))
+}
+
+// isTemporary reports whether a send failure is worth retrying.
+func isTemporary(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	if isSendmailTempFail(err) {
+		return true
+	}
+	// Errors of a Sender tell themselves, see WithSender.
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	return errors.As(err, &netErr) || errors.As(err, &opErr) || isConnectionError(err)
+}
@@ -0,0 +1,130 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dataAttempts counts the messages the server was offered, accepted or not.
+func dataAttempts(server *testServer) int {
+	n := 0
+	for _, command := range server.commandLog() {
+		if command == "DATA" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRetry(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.rejectData.Store(2)
+		s.rejectReply = "451 4.7.1 greylisted, try again later"
+	})
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithRetry(RetryPolicy{MaxAttempts: 3, InitialDelay: 20 * time.Millisecond, Multiplier: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "greylisted")); err != nil {
+		t.Fatal(err)
+	}
+	// The retries wait 20ms and then 40ms.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("sent after %s, want the backoff of 60ms", elapsed)
+	}
+	if n := dataAttempts(server); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	for _, test := range []struct {
+		reply    string
+		attempts int
+	}{
+		{"554 5.7.1 rejected", 1},
+		{"451 4.3.0 try again later", 2},
+	} {
+		server := newTestServer(t, func(s *testServer) {
+			s.rejectData.Store(2)
+			s.rejectReply = test.reply
+		})
+		hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+			WithoutConnectivityCheck(), WithRetry(RetryPolicy{MaxAttempts: 2, Multiplier: 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = hook.Fire(testEntry(logrus.ErrorLevel, "rejected"))
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) || !strings.HasPrefix(test.reply, fmt.Sprint(protoErr.Code)) {
+			t.Fatalf("%s: got %v", test.reply, err)
+		}
+		if n := dataAttempts(server); n != test.attempts {
+			t.Fatalf("%s: got %d attempts, want %d", test.reply, n, test.attempts)
+		}
+		// The error wasn't marked as sent, so the next entry goes out.
+		server.rejectData.Store(0)
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "rejected")); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(server.received()); n != 1 {
+			t.Fatalf("%s: got %d messages, want 1", test.reply, n)
+		}
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.rejectData.Store(1)
+		s.rejectReply = "421 4.3.2 shutting down"
+	})
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithRetry(RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, Multiplier: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	entry := testEntry(logrus.ErrorLevel, "cancelled")
+	entry.Context = ctx
+	if err := hook.Fire(entry); !errors.Is(err, ErrSendCancelled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want ErrSendCancelled", err)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages after the cancellation", n)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&textproto.Error{Code: 421, Msg: "service not available"}, true},
+		{fmt.Errorf("data: %w", &textproto.Error{Code: 451, Msg: "greylisted"}), true},
+		{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}, false},
+		{&textproto.Error{Code: 554, Msg: "transaction failed"}, false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{errors.New("template: bad"), false},
+	} {
+		if got := isTemporary(test.err); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
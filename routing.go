@@ -0,0 +1,96 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithRecipientsFor sends entries of the level to recipients instead of the hook recipients.
+func WithRecipientsFor(level logrus.Level, recipients ...string) MailOption {
+	return func(o *mailOptions) error {
+		if err := checkAddresses("recipient", recipients); err != nil {
+			return err
+		}
+		if o.levelRecipients == nil {
+			o.levelRecipients = make(map[logrus.Level][]string)
+		}
+		o.levelRecipients[level] = append(o.levelRecipients[level], recipients...)
+		return nil
+	}
+}
+
+// RecipientFunc returns the To addresses of the entry, e.g. the current on-call.
+// Returning none means the static recipients.
+type RecipientFunc func(entry *logrus.Entry) ([]string, error)
+
+// WithRecipientFunc resolves the recipients of every email with fn. When fn fails
+// or returns invalid addresses the error is reported and the static recipients are used.
+func WithRecipientFunc(fn RecipientFunc) MailOption {
+	return func(o *mailOptions) error {
+		if fn == nil {
+			return errors.New("recipient func must not be nil")
+		}
+		o.recipientFunc = fn
+		return nil
+	}
+}
+
+// recipientsFor returns the To addresses for the entry.
+func (m *mailer) recipientsFor(entry *logrus.Entry) []string {
+	if fn := m.options.recipientFunc; fn != nil {
+		recipients, err := fn(entry)
+		if err == nil {
+			err = checkAddresses("recipient", recipients)
+		}
+		if err != nil {
+			m.reportError(entry, fmt.Errorf("recipient func: %w", err))
+		} else if len(recipients) > 0 {
+			return recipients
+		}
+	}
+	if recipients, ok := m.options.levelRecipients[entry.Level]; ok && len(recipients) > 0 {
+		return recipients
+	}
+	return m.recipients
+}
+
+// dedupKey is the suppression key of the entry, see WithDedupKey and routedKey.
+// The key is taken from a copy with the normalized message, see WithDedupNormalization.
+// It is empty with WithoutDedup.
+func (m *mailer) dedupKey(entry *logrus.Entry) string {
+	if m.options.noDedup {
+		return ""
+	}
+	if len(m.options.normalizeRules) > 0 {
+		normalized := *entry
+		normalized.Message = NormalizeMessage(entry.Message, m.options.normalizeRules)
+		entry = &normalized
+	}
+	if m.options.dedupKey != nil {
+		return m.options.dedupKey(entry)
+	}
+	return MessageOnly(entry)
+}
+
+// routedKey makes the dedup key of an entry going to other recipients than the hook ones,
+// see WithRecipientsFor and WithRecipientFunc, specific to its recipient set, so an error
+// suppressed for one set is still sent to another. Entries for the hook recipients keep the
+// plain key.
+func (m *mailer) routedKey(key string, recipients string) string {
+	if key == "" || recipients == recipientsKey(m.recipients) {
+		return key
+	}
+	return recipients + "\x00" + key
+}
+
+// recipientsKey identifies a recipient set for rate limiting, so emails routed
+// elsewhere don't use up the budget of the hook recipients.
+func recipientsKey(recipients []string) string {
+	sorted := append([]string(nil), recipients...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
@@ -0,0 +1,171 @@
+package log_hooks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sentTo counts the recorded messages by their To header.
+func sentTo(t *testing.T, hook *MailHook) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for _, message := range hook.DryRunMessages() {
+		counts[parseMessage(t, string(message)).Header.Get("To")]++
+	}
+	return counts
+}
+
+func TestPerRecipientThrottling(t *testing.T) {
+	var mu sync.Mutex
+	reasons := make(map[SuppressReason]int)
+	hook := newDryRunHook(t, WithRecipientsFor(logrus.WarnLevel, "warnings@example.com"),
+		WithOnSuppressed(func(_ *logrus.Entry, reason SuppressReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			reasons[reason]++
+		}))
+
+	// The warning doesn't use up the budget of the on-call recipient, and the other way round.
+	for _, entry := range []*logrus.Entry{
+		testEntry(logrus.ErrorLevel, "database down"),
+		testEntry(logrus.WarnLevel, "disk almost full"),
+		testEntry(logrus.ErrorLevel, "queue stuck"),
+		testEntry(logrus.WarnLevel, "slow request"),
+	} {
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]int{"ops@example.com": 1, "warnings@example.com": 1}
+	if got := sentTo(t, hook); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if reasons[SuppressRateLimit] != 2 {
+		t.Fatalf("got suppressions %v, want 2 rate limited", reasons)
+	}
+}
+
+func TestPerRecipientThrottlingConcurrent(t *testing.T) {
+	hook := newDryRunHook(t, WithRecipientsFor(logrus.WarnLevel, "warnings@example.com"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, level := range []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel} {
+			wg.Add(1)
+			go func(entry *logrus.Entry) {
+				defer wg.Done()
+				if err := hook.Fire(entry); err != nil {
+					t.Error(err)
+				}
+			}(testEntry(level, fmt.Sprintf("distinct error %d", i)))
+		}
+	}
+	wg.Wait()
+
+	// Every recipient set gets exactly one email a minute.
+	want := map[string]int{"ops@example.com": 1, "warnings@example.com": 1}
+	if got := sentTo(t, hook); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if st := hook.Stats(); st.Suppressed != 98 {
+		t.Fatalf("got %d suppressed, want 98", st.Suppressed)
+	}
+}
+
+func TestMixedRoutingKeys(t *testing.T) {
+	hook := newDryRunHook(t, WithSuppressionWindows(0, time.Hour),
+		WithRecipientsFor(logrus.WarnLevel, "warnings@example.com"),
+		WithRecipientFunc(func(entry *logrus.Entry) ([]string, error) {
+			if entry.Data["team"] == "security" {
+				return []string{"security@example.com", "ciso@example.com"}, nil
+			}
+			return nil, nil
+		}))
+	security := func(level logrus.Level) *logrus.Entry {
+		entry := testEntry(level, "disk full")
+		entry.Data["team"] = "security"
+		return entry
+	}
+
+	// The same message sent to three recipient sets, each suppressing its own duplicates.
+	sent := countSent(t, hook,
+		testEntry(logrus.ErrorLevel, "disk full"),
+		testEntry(logrus.WarnLevel, "disk full"),
+		security(logrus.ErrorLevel),
+		testEntry(logrus.ErrorLevel, "disk full"),
+		testEntry(logrus.WarnLevel, "disk full"),
+		security(logrus.WarnLevel),
+	)
+	if sent != 3 {
+		t.Fatalf("got %d emails, want 3", sent)
+	}
+	want := map[string]int{"ops@example.com": 1, "warnings@example.com": 1, "security@example.com, ciso@example.com": 1}
+	if got := sentTo(t, hook); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The hook recipients keep the plain key, the others are keyed by the sorted set.
+	var keys []string
+	for _, state := range hook.SuppressionSnapshot() {
+		keys = append(keys, state.Key)
+	}
+	sort.Strings(keys)
+	wantKeys := []string{"ciso@example.com,security@example.com\x00disk full", "disk full", "warnings@example.com\x00disk full"}
+	if fmt.Sprintf("%q", keys) != fmt.Sprintf("%q", wantKeys) {
+		t.Fatalf("got keys %q, want %q", keys, wantKeys)
+	}
+}
+
+func TestDigestRouting(t *testing.T) {
+	hook := newDryRunHook(t, WithDigest(time.Hour),
+		WithRecipientsFor(logrus.WarnLevel, "warnings@example.com"),
+		WithRecipientFunc(func(entry *logrus.Entry) ([]string, error) {
+			if entry.Data["team"] == "security" {
+				return []string{"security@example.com"}, nil
+			}
+			return nil, nil
+		}))
+	security := testEntry(logrus.ErrorLevel, "login storm")
+	security.Data["team"] = "security"
+	for _, entry := range []*logrus.Entry{
+		testEntry(logrus.ErrorLevel, "disk full"),
+		testEntry(logrus.WarnLevel, "disk almost full"),
+		security,
+		testEntry(logrus.ErrorLevel, "disk full"),
+		testEntry(logrus.WarnLevel, "slow request"),
+	} {
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(hook.DryRunMessages()); n != 0 {
+		t.Fatalf("got %d messages before the window closed", n)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every recipient set gets a digest of its own entries.
+	subjects := make(map[string]string)
+	for _, message := range hook.DryRunMessages() {
+		msg := parseMessage(t, string(message))
+		subjects[msg.Header.Get("To")] = msg.Header.Get("Subject")
+		if body := decodedBody(t, msg); msg.Header.Get("To") != "security@example.com" && strings.Contains(body, "login storm") {
+			t.Fatalf("security entry sent to %s", msg.Header.Get("To"))
+		}
+	}
+	want := map[string]string{
+		"ops@example.com":      "app - digest: 2 entries",
+		"warnings@example.com": "app - digest: 2 entries",
+		"security@example.com": "app - digest: 1 entries",
+	}
+	if fmt.Sprint(subjects) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", subjects, want)
+	}
+}
@@ -0,0 +1,43 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+)
+
+// Sender delivers rendered emails, see WithSender. Errors with a Temporary method
+// returning true are retried like temporary SMTP failures, see WithRetry.
+type Sender interface {
+	Send(ctx context.Context, from string, to []string, message []byte) error
+}
+
+// Pinger is implemented by senders that can check the mail path without sending, see Ping.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// WithSender delivers the emails through sender instead of SMTP, e.g. to test the hook
+// or to use another transport. to holds all envelope recipients, also cc and bcc.
+// The host isn't dialed when the hook is created and the SMTP options don't apply.
+func WithSender(sender Sender) MailOption {
+	return func(o *mailOptions) error {
+		if sender == nil {
+			return errors.New("sender must not be nil")
+		}
+		o.transport = sender
+		return nil
+	}
+}
+
+// smtpSender is the default sender, it delivers through the hook host and the fallbacks.
+type smtpSender struct {
+	m *mailer
+}
+
+func (s smtpSender) Send(ctx context.Context, from string, to []string, message []byte) error {
+	return s.m.sendEndpoints(ctx, from, to, message)
+}
+
+func (s smtpSender) Ping(ctx context.Context) error {
+	return s.m.pingEndpoints(ctx)
+}
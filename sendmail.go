@@ -0,0 +1,80 @@
+package log_hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// exTempFail is the sysexits.h code sendmail exits with on temporary failures.
+const exTempFail = 75
+
+// NewMailHookSendmail creates a hook piping each email to a local sendmail binary
+// instead of dialing SMTP. The binary is run as "path args... -i -f sender -- recipients...".
+func NewMailHookSendmail(appname string, sender string, recipients []string, path string, args []string, opts ...MailOption) (*MailHook, error) {
+	options, err := newMailOptions("", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAddressParams(sender, recipients); err != nil {
+		return nil, err
+	}
+
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := buildMailer(appname, "", 0, sender, recipients, options, &sendmailTransport{
+		path:    resolved,
+		args:    append([]string(nil), args...),
+		timeout: options.sendTimeout,
+	})
+	return &MailHook{mailer: m}, nil
+}
+
+// sendmailTransport pipes messages to a sendmail compatible binary.
+type sendmailTransport struct {
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+func (t *sendmailTransport) Send(ctx context.Context, from string, to []string, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	args := append(append([]string(nil), t.args...), "-i", "-f", from, "--")
+	cmd := exec.CommandContext(ctx, t.path, append(args, to...)...)
+	// sendmail expects local line endings and converts them back to CRLF itself.
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n")))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return fmt.Errorf("sendmail: %w: %s", err, output)
+		}
+		return fmt.Errorf("sendmail: %w", err)
+	}
+	return nil
+}
+
+func (t *sendmailTransport) Ping(ctx context.Context) error {
+	_, err := exec.LookPath(t.path)
+	return err
+}
+
+// isSendmailTempFail reports whether sendmail exited with EX_TEMPFAIL.
+func isSendmailTempFail(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == exTempFail
+}
@@ -0,0 +1,79 @@
+package log_hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeSendmail writes a sendmail script running body and returns its path.
+func fakeSendmail(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sendmail")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSendmail(t *testing.T) {
+	dir := t.TempDir()
+	path := fakeSendmail(t, `printf '%s\n' "$@" > `+dir+`/argv && cat > `+dir+`/stdin`)
+	hook, err := NewMailHookSendmail("app", "alerts@example.com", []string{"ops@example.com", "dev@example.com"}, path, []string{"-odq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "piped")); err != nil {
+		t.Fatal(err)
+	}
+
+	argv, err := os.ReadFile(filepath.Join(dir, "argv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(argv)); strings.Join(got, " ") != "-odq -i -f alerts@example.com -- ops@example.com dev@example.com" {
+		t.Fatalf("got arguments %q", got)
+	}
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sendmail gets local line endings.
+	if strings.Contains(string(stdin), "\r") {
+		t.Fatalf("got CRLF in %q", stdin)
+	}
+	msg := parseMessage(t, string(stdin))
+	if msg.Header.Get("Subject") != "app - error" || !strings.Contains(decodedBody(t, msg), "MESSAGE: piped") {
+		t.Fatalf("got message %q", stdin)
+	}
+}
+
+func TestSendmailFailure(t *testing.T) {
+	for _, test := range []struct {
+		status    string
+		temporary bool
+	}{
+		{"67", false},
+		{"75", true},
+	} {
+		path := fakeSendmail(t, "echo 'user unknown' >&2; exit "+test.status)
+		hook, err := NewMailHookSendmail("app", "alerts@example.com", []string{"nobody@example.com"}, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = hook.Fire(testEntry(logrus.ErrorLevel, "undeliverable"))
+		if err == nil || err.Error() != "sendmail: exit status "+test.status+": user unknown" {
+			t.Fatalf("got %v", err)
+		}
+		if isTemporary(err) != test.temporary {
+			t.Fatalf("exit status %s: got temporary %v", test.status, isTemporary(err))
+		}
+	}
+
+	if _, err := NewMailHookSendmail("app", "alerts@example.com", []string{"ops@example.com"}, filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Fatal("missing sendmail accepted")
+	}
+}
@@ -0,0 +1,361 @@
+package log_hooks
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testServer is a minimal SMTP server recording what the hooks send.
+type testServer struct {
+	ln net.Listener
+
+	// startTLS is offered as STARTTLS, implicit runs it from the first byte.
+	startTLS *tls.Config
+	implicit *tls.Config
+	// users enables AUTH PLAIN and LOGIN, mapping usernames to passwords.
+	users map[string]string
+	// tokens enables AUTH XOAUTH2, mapping usernames to bearer tokens.
+	tokens map[string]string
+	// dataReply answers the end of DATA, "250 ok" by default.
+	dataReply string
+	// rejectData is the number of messages still to be rejected with rejectReply, a 554
+	// by default.
+	rejectData  atomic.Int32
+	rejectReply string
+	// hold, when set, is sent on when a message arrives and received from before it is
+	// answered, so a test knows the hook is busy and decides when it may go on.
+	hold chan struct{}
+
+	connections atomic.Int32
+
+	mu       sync.Mutex
+	commands []string
+	messages []string
+	// peers are the client certificate common names of the TLS sessions.
+	peers []string
+}
+
+// newTestServer starts a server on 127.0.0.1 configured by configure, which runs before
+// the server accepts connections. It is stopped when the test ends.
+func newTestServer(t testing.TB, configure func(s *testServer)) *testServer {
+	t.Helper()
+	s := &testServer{dataReply: "250 ok", rejectReply: "554 transaction failed"}
+	if configure != nil {
+		configure(s)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.implicit != nil {
+		ln = tls.NewListener(ln, s.implicit)
+	}
+	s.ln = ln
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.connections.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	if c, ok := conn.(*tls.Conn); ok && !s.handshake(c) {
+		return
+	}
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+	_, secure := conn.(*tls.Conn)
+
+	reply("220 test ESMTP")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		s.record(&s.commands, line)
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-test")
+			if s.startTLS != nil && !secure {
+				reply("250-STARTTLS")
+			}
+			if mechanisms := s.mechanisms(); mechanisms != "" {
+				reply("250-AUTH" + mechanisms)
+			}
+			reply("250 8BITMIME")
+		case "HELO":
+			reply("250 test")
+		case "STARTTLS":
+			if s.startTLS == nil || secure {
+				reply("502 not supported")
+				continue
+			}
+			reply("220 go ahead")
+			c := tls.Server(conn, s.startTLS)
+			if !s.handshake(c) {
+				return
+			}
+			conn, r, secure = c, bufio.NewReader(c), true
+		case "AUTH":
+			reply(s.auth(arg, reply, readLine))
+		case "DATA":
+			reply("354 end with .")
+			var message strings.Builder
+			for {
+				line, ok := readLine()
+				if !ok {
+					return
+				}
+				if line == "." {
+					break
+				}
+				message.WriteString(strings.TrimPrefix(line, "."))
+				message.WriteString("\r\n")
+			}
+			if s.hold != nil {
+				s.hold <- struct{}{}
+				<-s.hold
+			}
+			if s.rejectData.Add(-1) >= 0 {
+				reply(s.rejectReply)
+				continue
+			}
+			s.record(&s.messages, message.String())
+			reply(s.dataReply)
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *testServer) handshake(c *tls.Conn) bool {
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.Handshake(); err != nil {
+		return false
+	}
+	_ = c.SetDeadline(time.Time{})
+	if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+		s.record(&s.peers, certs[0].Subject.CommonName)
+	}
+	return true
+}
+
+// auth runs the AUTH exchange and returns the final reply.
+func (s *testServer) auth(arg string, reply func(string), readLine func() (string, bool)) string {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	challenge := func(prompt string) string {
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, _ := readLine()
+		s.record(&s.commands, line)
+		decoded, _ := base64.StdEncoding.DecodeString(line)
+		return string(decoded)
+	}
+
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		var response string
+		if initial == "" {
+			response = challenge("")
+		} else {
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			response = string(decoded)
+		}
+		parts := strings.Split(response, "\x00")
+		if len(parts) != 3 {
+			return "501 malformed"
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		username = challenge("Username:")
+		password = challenge("Password:")
+	case "XOAUTH2":
+		decoded, _ := base64.StdEncoding.DecodeString(initial)
+		user, token, _ := strings.Cut(strings.TrimSuffix(string(decoded), "\x01\x01"), "\x01auth=Bearer ")
+		if want, ok := s.tokens[strings.TrimPrefix(user, "user=")]; ok && want == token {
+			return "235 2.7.0 accepted"
+		}
+		// Like Gmail, the error is sent as a challenge the client answers with an empty line.
+		challenge(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`)
+		return "535 5.7.8 username and password not accepted"
+	default:
+		return "504 unrecognized mechanism"
+	}
+	if want, ok := s.users[username]; !ok || want != password {
+		return "535 5.7.8 authentication credentials invalid"
+	}
+	return "235 2.7.0 authentication successful"
+}
+
+// mechanisms returns the AUTH mechanisms to advertise, each with a leading space.
+func (s *testServer) mechanisms() string {
+	var mechanisms string
+	if s.users != nil {
+		mechanisms += " PLAIN LOGIN"
+	}
+	if s.tokens != nil {
+		mechanisms += " XOAUTH2"
+	}
+	return mechanisms
+}
+
+func (s *testServer) record(list *[]string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*list = append(*list, value)
+}
+
+// received returns the messages accepted so far.
+func (s *testServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *testServer) commandLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testServer) clientCertificates() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.peers...)
+}
+
+// testCA issues certificates for the test servers and clients.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// server issues a certificate for mail.test and 127.0.0.1.
+func (ca *testCA) server(t *testing.T) tls.Certificate {
+	return ca.issue(t, "mail.test", x509.ExtKeyUsageServerAuth)
+}
+
+// client issues a client certificate with the common name.
+func (ca *testCA) client(t *testing.T, name string) tls.Certificate {
+	return ca.issue(t, name, x509.ExtKeyUsageClientAuth)
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if usage == x509.ExtKeyUsageServerAuth {
+		template.DNSNames = []string{name}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// redirectDialer dials addr whatever address is asked for, so hooks can use a host name
+// other than 127.0.0.1.
+type redirectDialer struct {
+	addr string
+}
+
+func (d redirectDialer) DialContext(ctx context.Context, network string, _ string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, d.addr)
+}
+
+func testEntry(level logrus.Level, message string) *logrus.Entry {
+	return &logrus.Entry{Logger: logrus.New(), Time: time.Now(), Level: level, Message: message, Data: logrus.Fields{}}
+}
+
+func parseMessage(t *testing.T, message string) *mail.Message {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatalf("parse message: %v\n%s", err, message)
+	}
+	return msg
+}
@@ -0,0 +1,246 @@
+package log_hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AWSCredentials sign the requests of SESSender. They are an AWSCredentialsProvider
+// returning themselves.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+}
+
+func (c AWSCredentials) AWSCredentials(ctx context.Context) (AWSCredentials, error) {
+	return c, nil
+}
+
+// AWSCredentialsProvider returns the credentials for every request, so rotated ones are
+// picked up. An adapter for the credentials of the AWS SDK takes a few lines.
+type AWSCredentialsProvider interface {
+	AWSCredentials(ctx context.Context) (AWSCredentials, error)
+}
+
+// SESSender delivers emails through the SendEmail API of Amazon SES v2, see WithSender.
+// Throttling and server errors are temporary, so they are retried with WithRetry.
+// Errors of the API are returned as *SESError.
+type SESSender struct {
+	// Region is the AWS region, e.g. "eu-west-1".
+	Region      string
+	Credentials AWSCredentialsProvider
+	// ConfigurationSet is the SES configuration set of the emails, if any.
+	ConfigurationSet string
+	// Endpoint replaces https://email.<region>.amazonaws.com.
+	Endpoint string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// SESError is an error response of the SES API.
+type SESError struct {
+	StatusCode int
+	// Type is the exception name, e.g. "TooManyRequestsException".
+	Type    string
+	Message string
+}
+
+func (e *SESError) Error() string {
+	return fmt.Sprintf("ses: %s (%d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed later, for throttling and server errors.
+func (e *SESError) Temporary() bool {
+	switch e.Type {
+	case "TooManyRequestsException", "LimitExceededException", "ThrottlingException", "Throttling":
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type sesSendEmail struct {
+	FromEmailAddress     string         `json:"FromEmailAddress"`
+	Destination          sesDestination `json:"Destination"`
+	Content              sesContent     `json:"Content"`
+	ConfigurationSetName string         `json:"ConfigurationSetName,omitempty"`
+}
+
+type sesDestination struct {
+	ToAddresses []string `json:"ToAddresses"`
+}
+
+type sesContent struct {
+	Raw struct {
+		// Data is encoded as base64 by encoding/json.
+		Data []byte `json:"Data"`
+	} `json:"Raw"`
+}
+
+func (s *SESSender) Send(ctx context.Context, from string, to []string, message []byte) error {
+	if s.Credentials == nil {
+		return errors.New("ses: no credentials")
+	}
+	credentials, err := s.Credentials.AWSCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("ses credentials: %w", err)
+	}
+
+	request := sesSendEmail{
+		FromEmailAddress:     from,
+		Destination:          sesDestination{ToAddresses: to},
+		ConfigurationSetName: s.ConfigurationSet,
+	}
+	request.Content.Raw.Data = message
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + s.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signV4(req, body, credentials, s.Region, "ses", time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newSESError(resp, text)
+}
+
+func newSESError(resp *http.Response, body []byte) *SESError {
+	e := &SESError{StatusCode: resp.StatusCode, Type: resp.Header.Get("X-Amzn-ErrorType")}
+	var payload struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+		Type         string `json:"__type"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	e.Message = payload.Message
+	if e.Message == "" {
+		e.Message = payload.MessageUpper
+	}
+	if e.Type == "" {
+		e.Type = payload.Type
+	}
+	// Types may carry a namespace and details, as in "aws.ses#TooManyRequestsException:details".
+	e.Type, _, _ = strings.Cut(e.Type, ":")
+	if i := strings.LastIndexByte(e.Type, '#'); i >= 0 {
+		e.Type = e.Type[i+1:]
+	}
+	if e.Type == "" {
+		e.Type = http.StatusText(resp.StatusCode)
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// signV4 signs the request with AWS Signature Version 4.
+func signV4(req *http.Request, body []byte, credentials AWSCredentials, region string, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if credentials.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	key := signingKey(credentials.SecretAccessKey, now, region, service)
+	signature := sigV4Signature(key, amzDate, scope, canonicalRequest(req, signed, payloadHash))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+}
+
+// canonicalRequest returns the SigV4 canonical form of the request with the signed headers,
+// given in lower case and sorted.
+func canonicalRequest(req *http.Request, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// signingKey derives the key of the day, region and service from the secret access key.
+func signingKey(secret string, now time.Time, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func sigV4Signature(key []byte, amzDate string, scope string, canonical string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery sorts and escapes the query parameters as SigV4 requires.
+func canonicalQuery(values url.Values) string {
+	// Encode sorts by key, SigV4 wants %20 for spaces.
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,161 @@
+package log_hooks
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The vectors are from the AWS documentation and the get-vanilla case of the SigV4 test suite.
+func TestSigV4ReferenceVectors(t *testing.T) {
+	const secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+
+	key := signingKey(secret, time.Date(2012, 2, 15, 0, 0, 0, 0, time.UTC), "us-east-1", "iam")
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Fatalf("got signing key %s", got)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Date", "20150830T123600Z")
+	canonical := canonicalRequest(req, []string{"host", "x-amz-date"}, sha256Hex(nil))
+	want := "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if canonical != want {
+		t.Fatalf("got canonical request:\n%s\nwant:\n%s", canonical, want)
+	}
+	key = signingKey(secret, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), "us-east-1", "service")
+	signature := sigV4Signature(key, "20150830T123600Z", "20150830/us-east-1/service/aws4_request", canonical)
+	if signature != "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31" {
+		t.Fatalf("got signature %s", signature)
+	}
+}
+
+func TestSESSender(t *testing.T) {
+	credentials := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	var verified atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v2/email/outbound-emails" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+
+		// The server checks the signature like SES.
+		amzDate := r.Header.Get("X-Amz-Date")
+		date, err := time.Parse("20060102T150405Z", amzDate)
+		if err != nil {
+			t.Errorf("got X-Amz-Date %q", amzDate)
+		}
+		scope := amzDate[:8] + "/eu-west-1/ses/aws4_request"
+		signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date", "x-amz-security-token"}
+		r.URL.Host = r.Host
+		signature := sigV4Signature(signingKey("secret", date, "eu-west-1", "ses"), amzDate, scope, canonicalRequest(r, signed, sha256Hex(body)))
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + scope + ", SignedHeaders=" + strings.Join(signed, ";") + ", Signature=" + signature
+		if got := r.Header.Get("Authorization"); got != want {
+			t.Errorf("got Authorization %q, want %q", got, want)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "token" || r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+			t.Errorf("got header %v", r.Header)
+		}
+
+		var request sesSendEmail
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("got body %q: %v", body, err)
+		}
+		if request.FromEmailAddress != "alerts@example.com" || strings.Join(request.Destination.ToAddresses, ",") != "ops@example.com" ||
+			request.ConfigurationSetName != "alerts" || !strings.Contains(string(request.Content.Raw.Data), "MESSAGE: via ses") {
+			t.Errorf("got request %+v", request)
+		}
+		verified.Store(true)
+		_, _ = io.WriteString(w, `{"MessageId":"0100"}`)
+	}))
+	defer api.Close()
+
+	sender := &SESSender{Region: "eu-west-1", Credentials: credentials, ConfigurationSet: "alerts", Endpoint: api.URL + "/"}
+	hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", WithSender(sender))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "via ses")); err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Load() {
+		t.Fatal("no request")
+	}
+}
+
+func TestSESError(t *testing.T) {
+	for _, test := range []struct {
+		status    int
+		errorType string
+		body      string
+		want      SESError
+		temporary bool
+	}{
+		{http.StatusBadRequest, "MessageRejected:http://internal.amazon.com/coral/com.amazonaws.sesv2/", `{"message":"Email address is not verified."}`,
+			SESError{http.StatusBadRequest, "MessageRejected", "Email address is not verified."}, false},
+		{http.StatusTooManyRequests, "", `{"__type":"aws.ses#TooManyRequestsException","Message":"Maximum sending rate exceeded."}`,
+			SESError{http.StatusTooManyRequests, "TooManyRequestsException", "Maximum sending rate exceeded."}, true},
+		{http.StatusBadRequest, "LimitExceededException", `{"message":"Daily quota exceeded."}`,
+			SESError{http.StatusBadRequest, "LimitExceededException", "Daily quota exceeded."}, true},
+		{http.StatusInternalServerError, "", "backend failure",
+			SESError{http.StatusInternalServerError, "Internal Server Error", "backend failure"}, true},
+	} {
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.errorType != "" {
+				w.Header().Set("X-Amzn-ErrorType", test.errorType)
+			}
+			w.WriteHeader(test.status)
+			_, _ = io.WriteString(w, test.body)
+		}))
+		sender := &SESSender{Region: "eu-west-1", Credentials: AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret"}, Endpoint: api.URL}
+
+		err := sender.Send(context.Background(), "alerts@example.com", []string{"ops@example.com"}, []byte("Subject: test\r\n\r\nbody\r\n"))
+		api.Close()
+		var sesErr *SESError
+		if !errors.As(err, &sesErr) || *sesErr != test.want {
+			t.Fatalf("got %v, want %+v", err, test.want)
+		}
+		if sesErr.Temporary() != test.temporary || isTemporary(err) != test.temporary {
+			t.Fatalf("%s: got temporary %v, want %v", sesErr.Type, sesErr.Temporary(), test.temporary)
+		}
+	}
+}
+
+func TestSESThrottlingRetried(t *testing.T) {
+	var requests atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("X-Amzn-ErrorType", "TooManyRequestsException")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(w, `{"MessageId":"0100"}`)
+	}))
+	defer api.Close()
+
+	sender := &SESSender{Region: "eu-west-1", Credentials: AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret"}, Endpoint: api.URL}
+	hook, err := NewMailHook("app", "127.0.0.1", 25, "alerts@example.com", "ops@example.com", WithSender(sender),
+		WithRetry(RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, Multiplier: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "throttled")); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+}
@@ -0,0 +1,234 @@
+package log_hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSpoolRetryInterval is how often spooled emails are retried.
+const DefaultSpoolRetryInterval = time.Minute
+
+const (
+	spoolSuffix   = ".json"
+	corruptSuffix = ".corrupt"
+)
+
+// WithSpool writes emails that failed with a temporary error, after the retries, into dir and
+// delivers them in the background once the server is reachable again, also after a restart.
+// The spool keeps at most maxFiles emails and maxBytes bytes, evicting the oldest ones.
+// Unreadable spool files are renamed with a ".corrupt" suffix and skipped.
+func WithSpool(dir string, maxBytes int64, maxFiles int) MailOption {
+	return func(o *mailOptions) error {
+		if dir == "" {
+			return errors.New("spool directory must not be empty")
+		}
+		if maxBytes <= 0 || maxFiles <= 0 {
+			return fmt.Errorf("spool limits must be positive, got %d bytes and %d files", maxBytes, maxFiles)
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("spool directory: %w", err)
+		}
+		o.spoolDir = dir
+		o.spoolMaxBytes = maxBytes
+		o.spoolMaxFiles = maxFiles
+		return nil
+	}
+}
+
+// WithSpoolRetryInterval sets how often spooled emails are retried, see WithSpool.
+func WithSpoolRetryInterval(interval time.Duration) MailOption {
+	return func(o *mailOptions) error {
+		if interval <= 0 {
+			return fmt.Errorf("spool retry interval must be positive, got %s", interval)
+		}
+		o.spoolInterval = interval
+		return nil
+	}
+}
+
+// spoolFile is the content of a spool file.
+type spoolFile struct {
+	Created    time.Time `json:"created"`
+	Recipients []string  `json:"recipients"`
+	Message    []byte    `json:"message"`
+}
+
+// spool stores undelivered emails as one file each, named so that they sort oldest first.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	// mu serializes the changes to the directory.
+	mu  sync.Mutex
+	seq atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSpool(o mailOptions) *spool {
+	return &spool{
+		dir:      o.spoolDir,
+		maxBytes: o.spoolMaxBytes,
+		maxFiles: o.spoolMaxFiles,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// store writes the message and evicts the oldest files over the limits.
+func (s *spool) store(out outgoing) error {
+	content, err := json.Marshal(spoolFile{Created: time.Now(), Recipients: out.recipients, Message: out.message})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq.Add(1)%1000000, spoolSuffix)
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return s.evict()
+}
+
+// evict removes the oldest files until the spool fits its limits, the caller holds mu.
+func (s *spool) evict() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(names))
+	var total int64
+	for i, name := range names {
+		if info, err := os.Stat(filepath.Join(s.dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; i < len(names) && (len(names)-i > s.maxFiles || total > s.maxBytes); i++ {
+		if err := os.Remove(filepath.Join(s.dir, names[i])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+// list returns the spool files oldest first.
+func (s *spool) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), spoolSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load reads a spool file, corrupted ones are set aside.
+func (s *spool) load(name string) (outgoing, bool) {
+	path := filepath.Join(s.dir, name)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return outgoing{}, false
+	}
+	var file spoolFile
+	if err := json.Unmarshal(content, &file); err != nil || len(file.Message) == 0 || len(file.Recipients) == 0 {
+		_ = os.Rename(path, path+corruptSuffix)
+		return outgoing{}, false
+	}
+	return outgoing{message: file.Message, recipients: file.Recipients}, true
+}
+
+func (s *spool) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = os.Remove(filepath.Join(s.dir, name))
+}
+
+// run retries the spooled emails every interval until close.
+func (s *spool) run(interval time.Duration, redeliver func() error, report func(error)) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := redeliver(); err != nil {
+			report(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *spool) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// spoolError marks a failed send whose email was spooled.
+func spoolError(err error) error {
+	return fmt.Errorf("email spooled for later delivery: %w", err)
+}
+
+// redeliver sends the spooled emails oldest first. It stops at the first temporary failure,
+// the others would likely fail the same way, and drops the emails the server rejects.
+func (m *mailer) redeliver() error {
+	names, err := m.spool.list()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		out, ok := m.spool.load(name)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.options.sendTimeout)
+		err := m.send(ctx, out)
+		cancel()
+		var partial *partialDeliveryError
+		switch {
+		case err == nil || errors.As(err, &partial):
+			m.stats.sent.Add(1)
+		case isTemporary(err):
+			return nil
+		default:
+			m.stats.failed.Add(1)
+			m.reportError(nil, fmt.Errorf("spooled email %s dropped: %w", name, err))
+		}
+		m.spool.remove(name)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package log_hooks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newSpoolHook creates a hook retrying once and spooling to dir.
+func newSpoolHook(t *testing.T, server *testServer, dir string) *MailHook {
+	t.Helper()
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithRetry(RetryPolicy{MaxAttempts: 2, Multiplier: 1}),
+		WithSpool(dir, 1<<20, 10), WithSpoolRetryInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestServer(t, func(s *testServer) {
+		s.rejectData.Store(2)
+		s.rejectReply = "451 4.3.0 try again later"
+	})
+	hook := newSpoolHook(t, server, dir)
+
+	err := hook.Fire(testEntry(logrus.ErrorLevel, "unreachable"))
+	if err == nil || !strings.Contains(err.Error(), "email spooled for later delivery") {
+		t.Fatalf("got %v, want the email spooled", err)
+	}
+	// The email is spooled once the last attempt failed, not before.
+	if n := dataAttempts(server); n != 2 {
+		t.Fatalf("got %d attempts, want 2", n)
+	}
+	if files := spoolFiles(t, dir); len(files) != 1 {
+		t.Fatalf("got spool files %q, want 1", files)
+	}
+	if n := hook.Stats().Spooled; n != 1 {
+		t.Fatalf("got %d spooled, want 1", n)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new hook on the directory delivers the email when it starts.
+	restarted := newSpoolHook(t, server, dir)
+	if err := restarted.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages := sentMessages(t, server)
+	if !slices.Equal(messages, []string{"unreachable"}) {
+		t.Fatalf("got messages %q after the restart", messages)
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Fatalf("got spool files %q after the delivery", files)
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	for _, test := range []struct {
+		maxBytes int64
+		maxFiles int
+		want     []string
+	}{
+		{1 << 20, 3, []string{"message 3", "message 4", "message 5"}},
+		// A file with 100 bytes of message takes about 220 bytes, so two fit.
+		{500, 10, []string{"message 4", "message 5"}},
+	} {
+		s := newSpool(mailOptions{spoolDir: t.TempDir(), spoolMaxBytes: test.maxBytes, spoolMaxFiles: test.maxFiles})
+		for i := 1; i <= 5; i++ {
+			message := fmt.Sprintf("%-100s", fmt.Sprintf("message %d", i))
+			if err := s.store(outgoing{message: []byte(message), recipients: []string{"ops@example.com"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		names, err := s.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []string
+		for _, name := range names {
+			out, ok := s.load(name)
+			if !ok {
+				t.Fatalf("spool file %s unreadable", name)
+			}
+			kept = append(kept, strings.TrimSpace(string(out.message)))
+		}
+		if !slices.Equal(kept, test.want) {
+			t.Fatalf("%d bytes, %d files: kept %q, want %q", test.maxBytes, test.maxFiles, kept, test.want)
+		}
+	}
+}
+
+func TestSpoolCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "00000000000000000001-000001"+spoolSuffix)
+	if err := os.WriteFile(name, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, nil)
+	if err := newSpoolHook(t, server, dir).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name + corruptSuffix); err != nil {
+		t.Fatalf("corrupt file not set aside: %v", err)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages from a corrupt file", n)
+	}
+}
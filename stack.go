@@ -0,0 +1,119 @@
+package log_hooks
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultGoroutineDumpSize caps the dump of all goroutines sent for panic and fatal entries.
+const DefaultGoroutineDumpSize = 256 << 10
+
+// DefaultGoroutineDumpAttachThreshold is the dump size above which it is sent as goroutines.txt.
+const DefaultGoroutineDumpAttachThreshold = 32 << 10
+
+// WithoutStackTrace sends emails without the STACKTRACE section, also for panic and fatal entries.
+func WithoutStackTrace() MailOption {
+	return func(o *mailOptions) error {
+		o.stack.never = true
+		return nil
+	}
+}
+
+// WithStderrStackTraceLevel prints the stack trace for this level and more severe ones only,
+// by default for all levels of the stderr hook, see WithStackTraceLevel.
+func WithStderrStackTraceLevel(level logrus.Level) StderrOption {
+	return func(hook *StderrHook) {
+		hook.stack.level = &level
+	}
+}
+
+// WithoutStderrStackTrace prints the entries without the stack trace, see WithoutStackTrace.
+func WithoutStderrStackTrace() StderrOption {
+	return func(hook *StderrHook) {
+		hook.stack.never = true
+	}
+}
+
+// stackPolicy decides which entries carry a stack trace.
+type stackPolicy struct {
+	// level is the least severe level with a stack trace, nil for the default.
+	level *logrus.Level
+	never bool
+}
+
+// includes reports whether entries of the level carry a stack trace, by default those
+// at least as severe as defaultLevel.
+func (p stackPolicy) includes(level logrus.Level, defaultLevel logrus.Level) bool {
+	if p.never {
+		return false
+	}
+	if p.level != nil {
+		defaultLevel = *p.level
+	}
+	return level <= defaultLevel
+}
+
+// WithoutGoroutineDump sends the stack of the logging goroutine for panic and fatal
+// entries too, instead of the stacks of all goroutines.
+func WithoutGoroutineDump() MailOption {
+	return func(o *mailOptions) error {
+		o.noGoroutineDump = true
+		return nil
+	}
+}
+
+// WithGoroutineDumpLimits caps the dump of all goroutines sent for panic and fatal
+// entries at maxSize bytes and attaches it as goroutines.txt when it is larger than
+// attachThreshold bytes.
+func WithGoroutineDumpLimits(maxSize int, attachThreshold int) MailOption {
+	return func(o *mailOptions) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("goroutine dump size must be positive, got %d", maxSize)
+		}
+		if attachThreshold < 0 {
+			return fmt.Errorf("goroutine dump attach threshold must not be negative, got %d", attachThreshold)
+		}
+		o.goroutineDumpSize = maxSize
+		o.goroutineDumpAttachThreshold = attachThreshold
+		return nil
+	}
+}
+
+// dumpsGoroutines reports whether emails for the level carry the stacks of all goroutines.
+func (m *mailer) dumpsGoroutines(level logrus.Level) bool {
+	return !m.options.noGoroutineDump && level <= logrus.FatalLevel
+}
+
+// goroutineDump returns the stacks of all goroutines, truncated to the dump size.
+func (m *mailer) goroutineDump() string {
+	size := m.options.goroutineDumpSize
+	if size == 0 {
+		size = DefaultGoroutineDumpSize
+	}
+
+	buf := make([]byte, size)
+	n := runtime.Stack(buf, true)
+	if n == len(buf) {
+		return string(buf[:n]) + fmt.Sprintf("\n...[goroutine dump truncated at %d bytes]\n", size)
+	}
+	return string(buf[:n])
+}
+
+// attachesDump reports whether the goroutine dump of data is too large to be inlined.
+func (m *mailer) attachesDump(data BodyData) bool {
+	threshold := m.options.goroutineDumpAttachThreshold
+	if threshold == 0 {
+		threshold = DefaultGoroutineDumpAttachThreshold
+	}
+	return data.goroutineDump && len(data.Stack) > threshold
+}
+
+// stackFile is the name of the stack trace attachment.
+func stackFile(data BodyData) string {
+	if data.goroutineDump {
+		return "goroutines.txt"
+	}
+	return "stack.txt"
+}
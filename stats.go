@@ -0,0 +1,119 @@
+package log_hooks
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are the delivery counters of a hook, see Stats.
+type Stats struct {
+	// Sent is the number of emails delivered, Failed the number given up on.
+	Sent   int64
+	Failed int64
+	// DialErrors, AuthErrors and DataErrors count failed attempts by the step that failed:
+	// connecting, authenticating or the MAIL, RCPT and DATA commands.
+	DialErrors int64
+	AuthErrors int64
+	DataErrors int64
+	// Suppressed is the number of entries not sent because of the rate limits.
+	Suppressed int64
+	// Dropped is the number of emails discarded because the async queue was full.
+	Dropped int64
+	// Queued is the number of emails waiting in the async queue.
+	Queued int64
+	// Spooled is the number of emails written to the spool, see WithSpool.
+	Spooled int64
+	// Evicted is the number of errors the dedup store of the hook forgot to stay within
+	// WithMaxTrackedErrors, shared by the hooks using the store. It and the following
+	// counters come from the SuppressionStore when one is set.
+	Evicted int64
+	// Expired is the number of errors the dedup store forgot after their window, see WithOnEvict.
+	Expired int64
+	// KeyHits and KeyMisses count the emails for errors the dedup store already knew,
+	// and for new ones. Many misses mean high-cardinality messages, see WithDedupNormalization.
+	KeyHits   int64
+	KeyMisses int64
+}
+
+// stats holds the counters of a mailer.
+type stats struct {
+	sent       atomic.Int64
+	failed     atomic.Int64
+	dialErrors atomic.Int64
+	authErrors atomic.Int64
+	dataErrors atomic.Int64
+	suppressed atomic.Int64
+	spooled    atomic.Int64
+}
+
+// Stats returns the delivery counters of the hook. It is safe to call concurrently with Fire.
+func (m *mailer) Stats() Stats {
+	s := Stats{
+		Sent:       m.stats.sent.Load(),
+		Failed:     m.stats.failed.Load(),
+		DialErrors: m.stats.dialErrors.Load(),
+		AuthErrors: m.stats.authErrors.Load(),
+		DataErrors: m.stats.dataErrors.Load(),
+		Suppressed: m.stats.suppressed.Load(),
+		Spooled:    m.stats.spooled.Load(),
+	}
+	var store interface{ Stats() SuppressionStats } = m.store
+	if m.options.suppressionStore != nil {
+		store, _ = m.options.suppressionStore.(interface{ Stats() SuppressionStats })
+	}
+	if store != nil {
+		storeStats := store.Stats()
+		s.Evicted = storeStats.Evicted
+		s.Expired = storeStats.Expired
+		s.KeyHits = storeStats.KeyHits
+		s.KeyMisses = storeStats.KeyMisses
+	}
+	if m.queue != nil {
+		s.Dropped = m.queue.dropped.Load()
+		s.Queued = int64(len(m.queue.messages))
+	}
+	return s
+}
+
+// WithExpvar publishes the Stats of the hook as name in the "log_hooks" expvar map.
+func WithExpvar(name string) MailOption {
+	return func(o *mailOptions) error {
+		if name == "" {
+			return errors.New("expvar name must not be empty")
+		}
+		o.expvarName = name
+		return nil
+	}
+}
+
+var (
+	expvarOnce sync.Once
+	expvarMap  *expvar.Map
+)
+
+// publishStats adds the stats of the mailer to the "log_hooks" expvar map.
+func (m *mailer) publishStats(name string) {
+	expvarOnce.Do(func() { expvarMap = expvar.NewMap("log_hooks") })
+	expvarMap.Set(name, expvar.Func(func() interface{} { return m.Stats() }))
+}
+
+// authStepError marks errors of the AUTH step, so they are counted as AuthErrors.
+type authStepError struct {
+	err error
+}
+
+func (e *authStepError) Error() string { return e.err.Error() }
+
+func (e *authStepError) Unwrap() error { return e.err }
+
+// countConnectError counts a failed acquire as a dial or an auth error.
+func (m *mailer) countConnectError(err error) {
+	var authErr *authStepError
+	if errors.As(err, &authErr) {
+		m.stats.authErrors.Add(1)
+		return
+	}
+	m.stats.dialErrors.Add(1)
+}
@@ -0,0 +1,94 @@
+package log_hooks
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStatsConcurrent(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithSuppressionWindows(0, time.Hour), WithExpvar("stats-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half of the entries are duplicates.
+			_ = hook.Fire(testEntry(logrus.ErrorLevel, fmt.Sprintf("error %d", i%10)))
+			_ = hook.Stats()
+		}(i)
+	}
+	wg.Wait()
+
+	st := hook.Stats()
+	if st.Sent != 10 || st.Suppressed != 10 || st.Failed != 0 {
+		t.Fatalf("got %+v, want 10 sent and 10 suppressed", st)
+	}
+	if n := len(server.received()); n != 10 {
+		t.Fatalf("got %d messages, want 10", n)
+	}
+	published := expvar.Get("log_hooks").(*expvar.Map).Get("stats-test")
+	if published == nil || !strings.Contains(published.String(), `"Sent":10`) {
+		t.Fatalf("got expvar %v", published)
+	}
+}
+
+func TestStatsErrors(t *testing.T) {
+	unreachable := newTestServer(t, nil)
+	_ = unreachable.ln.Close()
+	rejecting := newTestServer(t, func(s *testServer) { s.dataReply = "554 rejected" })
+	authenticating := newTestServer(t, func(s *testServer) { s.users = map[string]string{} })
+
+	for name, tc := range map[string]struct {
+		new  func() (*mailer, error)
+		want func(Stats) int64
+	}{
+		"dial": {func() (*mailer, error) {
+			hook, err := NewMailHook("app", "127.0.0.1", unreachable.port(), "alerts@example.com", "ops@example.com", WithoutConnectivityCheck())
+			return mailerOf(hook, err)
+		}, func(st Stats) int64 { return st.DialErrors }},
+		"auth": {func() (*mailer, error) {
+			hook, err := NewMailAuthHook("app", "127.0.0.1", authenticating.port(), "alerts@example.com", "ops@example.com", "alerts", "secret")
+			if err != nil {
+				return nil, err
+			}
+			return hook.mailer, nil
+		}, func(st Stats) int64 { return st.AuthErrors }},
+		"data": {func() (*mailer, error) {
+			hook, err := NewMailHook("app", "127.0.0.1", rejecting.port(), "alerts@example.com", "ops@example.com")
+			return mailerOf(hook, err)
+		}, func(st Stats) int64 { return st.DataErrors }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, err := tc.new()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.fire(testEntry(logrus.ErrorLevel, "failing")); err == nil {
+				t.Fatal("send didn't fail")
+			}
+			st := m.Stats()
+			if tc.want(st) != 1 || st.Failed != 1 || st.Sent != 0 {
+				t.Fatalf("got %+v", st)
+			}
+		})
+	}
+}
+
+func mailerOf(hook *MailHook, err error) (*mailer, error) {
+	if err != nil {
+		return nil, err
+	}
+	return hook.mailer, nil
+}
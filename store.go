@@ -0,0 +1,179 @@
+package log_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SuppressionStore decides which errors are duplicates, so the suppression state can live in
+// a database or be shared between loggers and the replicas of a service. *DedupStore,
+// RedisStore and MemcacheStore implement it. Keys are the dedup keys, see WithDedupKey.
+type SuppressionStore interface {
+	// TryAcquire reports whether the error may be sent, claiming it for window when it may,
+	// so of the concurrent callers with the same key a single one is let through.
+	TryAcquire(ctx context.Context, key string, window time.Duration) (bool, error)
+	// MarkSent starts the window of the error again once its email was sent.
+	MarkSent(ctx context.Context, key string, window time.Duration) error
+	// Release gives up the claim of TryAcquire when the email couldn't be sent, so the error
+	// isn't suppressed although nobody was told about it.
+	Release(ctx context.Context, key string) error
+}
+
+// SuppressionStats are the counters of a SuppressionStore. Stores with a
+// Stats() SuppressionStats method, like *DedupStore, report them in the Stats of the hook.
+type SuppressionStats struct {
+	// Tracked is the number of errors the store remembers.
+	Tracked int
+	// Evicted and Expired count the errors forgotten to stay within a limit and after their window.
+	Evicted int64
+	Expired int64
+	// KeyHits and KeyMisses count the sends of known and of new errors.
+	KeyHits   int64
+	KeyMisses int64
+}
+
+const (
+	// DefaultSuppressionStoreTimeout bounds every call of a SuppressionStore.
+	DefaultSuppressionStoreTimeout = 500 * time.Millisecond
+	// DefaultSuppressionStorePause is how long a failed SuppressionStore is not asked again.
+	DefaultSuppressionStorePause = 30 * time.Second
+)
+
+// WithSuppressionStore makes store decide on the duplicates instead of the dedup store of the
+// hook, which still rate limits the recipients, threads the emails and counts the suppressed
+// entries. Without it the hook uses its dedup store, see WithDedupStore. The store fails open:
+// when it errors or doesn't answer within DefaultSuppressionStoreTimeout the email is sent, the
+// error is reported to the error handler and the store is skipped for DefaultSuppressionStorePause.
+func WithSuppressionStore(store SuppressionStore) MailOption {
+	return func(o *mailOptions) error {
+		if store == nil {
+			return errors.New("suppression store must not be nil")
+		}
+		o.suppressionStore = store
+		return nil
+	}
+}
+
+// storeAcquire asks the suppression store, failing open. claimed tells whether the store holds
+// the key for this send, see storeRelease.
+func (m *mailer) storeAcquire(entry *logrus.Entry, key string, window time.Duration) (ok bool, claimed bool) {
+	if window <= 0 || time.Now().UnixNano() < m.storePause.Load() {
+		return true, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSuppressionStoreTimeout)
+	defer cancel()
+	ok, err := m.options.suppressionStore.TryAcquire(ctx, key, window)
+	if err != nil {
+		m.storeFailed(entry, err)
+		return true, false
+	}
+	return ok, ok
+}
+
+// storeMarkSent tells the suppression store the email was sent. It may run in the background,
+// after logrus reused the entry, so failures are reported without it.
+func (m *mailer) storeMarkSent(key string, window time.Duration) {
+	if window <= 0 || time.Now().UnixNano() < m.storePause.Load() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSuppressionStoreTimeout)
+	defer cancel()
+	if err := m.options.suppressionStore.MarkSent(ctx, key, window); err != nil {
+		m.storeFailed(nil, err)
+	}
+}
+
+// storeRelease gives the claim of a send that failed back to the suppression store. Like
+// storeMarkSent it may run in the background.
+func (m *mailer) storeRelease(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSuppressionStoreTimeout)
+	defer cancel()
+	if err := m.options.suppressionStore.Release(ctx, key); err != nil {
+		m.storeFailed(nil, err)
+	}
+}
+
+func (m *mailer) storeFailed(entry *logrus.Entry, err error) {
+	m.storePause.Store(time.Now().Add(DefaultSuppressionStorePause).UnixNano())
+	m.reportError(entry, fmt.Errorf("suppression store unavailable, sending without it: %w", err))
+}
+
+// claim is a send recorded by TryAcquire until MarkSent or Release, see SuppressionStore.
+type claim struct {
+	at   time.Time
+	prev time.Time
+}
+
+// TryAcquire implements SuppressionStore with the message window of the error,
+// see WithWindowMode. The recipient sets are not rate limited. It never fails.
+func (es *DedupStore) TryAcquire(_ context.Context, key string, window time.Duration) (bool, error) {
+	es.observeWindow(window)
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	now := es.now()
+	if start, ok := es.windowStart(key); ok && start.Add(window).After(now) {
+		return false, nil
+	}
+	if _, ok := es.recentKeys[key]; ok {
+		es.hits.Add(1)
+	} else {
+		es.misses.Add(1)
+	}
+	es.errToClaim[key] = claim{at: now, prev: es.errToTime[key]}
+	es.errToTime[key] = now
+	es.touch(key, now)
+	es.sweep(now)
+	return true, nil
+}
+
+// MarkSent implements SuppressionStore, the window is the one of TryAcquire.
+func (es *DedupStore) MarkSent(_ context.Context, key string, _ time.Duration) error {
+	defer es.notifyDropped()
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	now := es.now()
+	delete(es.errToClaim, key)
+	es.errToTime[key] = now
+	es.touch(key, now)
+	return nil
+}
+
+// Release implements SuppressionStore, restoring the send before the claim unless the error
+// was sent since.
+func (es *DedupStore) Release(_ context.Context, key string) error {
+	es.errToTimeMu.Lock()
+	defer es.errToTimeMu.Unlock()
+	c, ok := es.errToClaim[key]
+	if !ok {
+		return nil
+	}
+	delete(es.errToClaim, key)
+	if !es.errToTime[key].Equal(c.at) {
+		return nil
+	}
+	if c.prev.IsZero() {
+		delete(es.errToTime, key)
+	} else {
+		es.errToTime[key] = c.prev
+	}
+	return nil
+}
+
+// Stats returns the counters of the store.
+func (es *DedupStore) Stats() SuppressionStats {
+	es.errToTimeMu.RLock()
+	tracked := es.recent.Len()
+	es.errToTimeMu.RUnlock()
+	return SuppressionStats{
+		Tracked:   tracked,
+		Evicted:   es.evicted.Load(),
+		Expired:   es.expired.Load(),
+		KeyHits:   es.hits.Load(),
+		KeyMisses: es.misses.Load(),
+	}
+}
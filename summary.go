@@ -0,0 +1,168 @@
+package log_hooks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithSuppressionSummary sends a summary when the window of a suppressed error elapses, even if
+// the error stopped occurring: how often it occurred and the fields of the first suppressed entry.
+// Errors that were not suppressed get no summary. Close sends the pending summaries.
+// Hooks sharing a dedup store each summarize the entries they suppressed.
+func WithSuppressionSummary() MailOption {
+	return func(o *mailOptions) error {
+		o.summary = true
+		return nil
+	}
+}
+
+// summaryGroup is a suppressed error waiting for the end of its window.
+type summaryGroup struct {
+	key        string
+	first      BodyData
+	recipients []string
+	count      int
+	sent       int
+	since      time.Time
+	last       time.Time
+	timer      *time.Timer
+}
+
+// summarizer sends a summaryGroup when its window elapses. It belongs to the hook rather than
+// to the store: sending needs the recipients, templates and transport of a hook, a DedupStore
+// may be shared by hooks differing in all of them, a SuppressionStore lives in another process,
+// and neither is closed, so nothing would stop the timers.
+type summarizer struct {
+	render func(group *summaryGroup) []byte
+	send   func(message []byte, recipients []string) error
+	// report receives the errors of the summaries sent by the timers.
+	report func(err error)
+
+	mu     sync.Mutex
+	groups map[string]*summaryGroup
+	closed bool
+}
+
+func newSummarizer(render func(*summaryGroup) []byte, send func([]byte, []string) error, report func(error)) *summarizer {
+	return &summarizer{
+		render: render,
+		send:   send,
+		report: report,
+		groups: make(map[string]*summaryGroup),
+	}
+}
+
+// add counts a suppressed entry, data is only called for the first one of the window, after
+// sent emails.
+func (s *summarizer) add(key string, until time.Time, recipients []string, sent int, data func() BodyData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	now := time.Now()
+	if group, ok := s.groups[key]; ok {
+		group.count++
+		group.last = now
+		return
+	}
+
+	first := data()
+	// The entry may be reused by logrus after Fire.
+	fields := make(logrus.Fields, len(first.Data))
+	for k, v := range first.Data {
+		fields[k] = v
+	}
+	first.Data = fields
+
+	group := &summaryGroup{key: key, first: first, recipients: recipients, count: 1, sent: sent, since: now, last: now}
+	group.timer = time.AfterFunc(until.Sub(now), func() {
+		if err := s.flush(group); err != nil {
+			s.report(err)
+		}
+	})
+	s.groups[key] = group
+}
+
+// flush sends the summary of the group unless it was sent already.
+func (s *summarizer) flush(group *summaryGroup) error {
+	s.mu.Lock()
+	if s.groups[group.key] != group {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.groups, group.key)
+	s.mu.Unlock()
+
+	if group.count == 0 {
+		return nil
+	}
+	return s.send(s.render(group), group.recipients)
+}
+
+// close stops the timers and sends the pending summaries.
+func (s *summarizer) close() error {
+	s.mu.Lock()
+	s.closed = true
+	groups := make([]*summaryGroup, 0, len(s.groups))
+	for _, group := range s.groups {
+		group.timer.Stop()
+		groups = append(groups, group)
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, group := range groups {
+		errs = append(errs, s.flush(group))
+	}
+	return errors.Join(errs...)
+}
+
+// summarize counts a suppressed entry when summaries are enabled.
+func (m *mailer) summarize(entry *logrus.Entry, key string, recipients []string, until time.Time) {
+	if m.summaries == nil {
+		return
+	}
+	sent := m.store.windowSends(key)
+	m.summaries.add(key, until, recipients, sent, func() BodyData { return m.bodyData(entry) })
+}
+
+// summaryMessage renders the summary email, in the thread of the error.
+func (m *mailer) summaryMessage(group *summaryGroup) []byte {
+	var body strings.Builder
+	if group.sent > 1 {
+		// The burst was emailed, the count is of the entries after it.
+		fmt.Fprintf(&body, "SUMMARY: %s occurred %d more times after %d emails between %s and %s\n",
+			group.first.Message, group.count, group.sent, m.formatTime(group.since), m.formatTime(group.last))
+	} else {
+		fmt.Fprintf(&body, "SUMMARY: %s occurred %d times between %s and %s\n",
+			group.first.Message, group.count, m.formatTime(group.since), m.formatTime(group.last))
+	}
+	fmt.Fprintf(&body, "HOST: %s (pid %d)\n", m.hostname, m.pid)
+	if m.options.environment != "" {
+		fmt.Fprintf(&body, "ENV: %s\n", m.options.environment)
+	}
+	fmt.Fprintf(&body, "LEVEL: %s\n", group.first.Level)
+	fmt.Fprintf(&body, "FIRST TIME: %s\n", group.first.FormattedTime)
+	fmt.Fprintf(&body, "DATA: %s\n", jsonIndent(group.first.Data))
+
+	line, _, _ := strings.Cut(group.first.Message, "\n")
+	subject := fmt.Sprintf("%s - summary: %dx %s", m.appName, group.count, line)
+	level, err := logrus.ParseLevel(group.first.Level)
+	if err != nil {
+		level = logrus.ErrorLevel
+	}
+	header := headerData{
+		recipients: group.recipients,
+		subject:    subject,
+		date:       time.Now(),
+		level:      level,
+		inReplyTo:  m.store.thread(group.key),
+	}
+	return m.assemble(header, textPart("text/plain; charset=utf-8", body.String())).Bytes()
+}
@@ -0,0 +1,75 @@
+package log_hooks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// waitMessages waits until the hook recorded n messages.
+func waitMessages(t *testing.T, hook *MailHook, n int) [][]byte {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := hook.DryRunMessages()
+		if len(messages) >= n {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages, want %d", len(messages), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSuppressionSummary(t *testing.T) {
+	hook := newDryRunHook(t, WithSuppressionSummary(), WithSuppressionWindows(0, 200*time.Millisecond))
+	defer func() { _ = hook.Close() }()
+
+	first := testEntry(logrus.ErrorLevel, "upstream down")
+	first.Data["upstream"] = "billing"
+	if n := countSent(t, hook, first, testEntry(logrus.ErrorLevel, "upstream down"),
+		testEntry(logrus.ErrorLevel, "upstream down"), testEntry(logrus.ErrorLevel, "upstream down")); n != 1 {
+		t.Fatalf("got %d emails, want 1", n)
+	}
+
+	// The summary follows when the window closes, in the thread of the error.
+	messages := waitMessages(t, hook, 2)
+	alert, summary := parseMessage(t, string(messages[0])), parseMessage(t, string(messages[1]))
+	if subject := summary.Header.Get("Subject"); subject != "app - summary: 3x upstream down" {
+		t.Fatalf("got subject %q", subject)
+	}
+	if got, want := summary.Header.Get("In-Reply-To"), alert.Header.Get("Message-Id"); got != want {
+		t.Fatalf("summary replies to %q, want %q", got, want)
+	}
+	body := decodedBody(t, summary)
+	if !strings.Contains(body, "SUMMARY: upstream down occurred 3 times between") {
+		t.Fatalf("body doesn't count the suppressed entries:\n%s", body)
+	}
+
+	// The counts start over, an error that isn't suppressed gets no summary.
+	if n := countSent(t, hook, testEntry(logrus.ErrorLevel, "upstream down")); n != 1 {
+		t.Fatal("error suppressed after its window")
+	}
+	time.Sleep(400 * time.Millisecond)
+	if n := len(hook.DryRunMessages()); n != 3 {
+		t.Fatalf("got %d messages, want no empty summary", n)
+	}
+}
+
+func TestSuppressionSummaryClose(t *testing.T) {
+	hook := newDryRunHook(t, WithSuppressionSummary(), WithSuppressionWindows(0, time.Hour))
+	countSent(t, hook, testEntry(logrus.ErrorLevel, "disk full"), testEntry(logrus.ErrorLevel, "disk full"))
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages := hook.DryRunMessages()
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want the summary sent on Close", len(messages))
+	}
+	if subject := parseMessage(t, string(messages[1])).Header.Get("Subject"); subject != "app - summary: 1x disk full" {
+		t.Fatalf("got subject %q", subject)
+	}
+}
@@ -0,0 +1,57 @@
+package log_hooks
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SuppressReason tells why an entry was not sent, see WithOnSuppressed.
+type SuppressReason int
+
+const (
+	// SuppressRateLimit means the recipients already got their emails for now,
+	// see WithSuppressionWindows and WithTokenBucket.
+	SuppressRateLimit SuppressReason = iota + 1
+	// SuppressDuplicate means the same error was sent within its message window.
+	SuppressDuplicate
+	// SuppressStore means the SuppressionStore holds the error, e.g. another replica sent it,
+	// see WithSuppressionStore.
+	SuppressStore
+)
+
+func (r SuppressReason) String() string {
+	switch r {
+	case SuppressRateLimit:
+		return "rate limit"
+	case SuppressDuplicate:
+		return "duplicate"
+	case SuppressStore:
+		return "suppression store"
+	default:
+		return "unknown"
+	}
+}
+
+// WithOnSuppressed calls fn for every entry the dedup store suppresses, e.g. to count them or
+// log them at debug level. fn runs in Fire, after the store is unlocked, and must not keep
+// the entry, logrus may reuse it.
+func WithOnSuppressed(fn func(entry *logrus.Entry, reason SuppressReason)) MailOption {
+	return func(o *mailOptions) error {
+		if fn == nil {
+			return errors.New("suppression callback must not be nil")
+		}
+		o.onSuppressed = fn
+		return nil
+	}
+}
+
+// suppress records a suppressed entry.
+func (m *mailer) suppress(entry *logrus.Entry, key string, recipients []string, blocked suppression) {
+	m.store.suppress(key)
+	m.stats.suppressed.Add(1)
+	m.summarize(entry, key, recipients, blocked.until)
+	if m.options.onSuppressed != nil {
+		m.options.onSuppressed(entry, blocked.reason)
+	}
+}
@@ -0,0 +1,72 @@
+package log_hooks
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateFuncs returns the functions of the subject and body templates merged with extra:
+// json indents a value, upper upper-cases a string, truncate cuts a string to n characters
+// and rfc3339 formats a time. Parse custom body templates with them, see WithBodyTemplate.
+func TemplateFuncs(extra template.FuncMap) template.FuncMap {
+	funcs := template.FuncMap{
+		"json":     jsonIndent,
+		"upper":    strings.ToUpper,
+		"truncate": truncateText,
+		"rfc3339":  func(t time.Time) string { return t.Format(time.RFC3339) },
+	}
+	for name, fn := range extra {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// WithTemplateFuncs adds functions to the subject and body templates, see TemplateFuncs.
+// A failing or panicking function makes the hook fall back to the default template and
+// report the error, see WithOnError.
+func WithTemplateFuncs(funcs template.FuncMap) MailOption {
+	return func(o *mailOptions) error {
+		if len(funcs) == 0 {
+			return errors.New("template funcs must not be empty")
+		}
+		if o.templateFuncs == nil {
+			o.templateFuncs = template.FuncMap{}
+		}
+		for name, fn := range funcs {
+			o.templateFuncs[name] = fn
+		}
+		return nil
+	}
+}
+
+// truncateText cuts s to at most n characters, marking the cut with an ellipsis.
+func truncateText(n int, s string) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// parseTemplates parses the subject template and binds the functions to the body template
+// once all options are applied, so WithTemplateFuncs may come after the templates.
+func (o *mailOptions) parseTemplates() error {
+	funcs := TemplateFuncs(o.templateFuncs)
+	if o.subjectText != "" {
+		tmpl, err := template.New("subject").Funcs(funcs).Parse(o.subjectText)
+		if err != nil {
+			return err
+		}
+		o.subjectTemplate = tmpl
+	}
+	if o.bodyTemplate != nil && o.templateFuncs != nil {
+		tmpl, err := o.bodyTemplate.Clone()
+		if err != nil {
+			return err
+		}
+		o.bodyTemplate = tmpl.Funcs(funcs)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package log_hooks
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// TimeNames translates the month and weekday names of time layouts, see WithTimeNames.
+// Empty names keep the English ones.
+type TimeNames struct {
+	// Months and ShortMonths replace "January" and "Jan", starting with January.
+	Months      [12]string
+	ShortMonths [12]string
+	// Days and ShortDays replace "Monday" and "Mon", starting with Sunday like time.Weekday.
+	Days      [7]string
+	ShortDays [7]string
+}
+
+// RussianTimeNames has Russian names, months in the genitive case as in "2 января".
+var RussianTimeNames = TimeNames{
+	Months:      [12]string{"января", "февраля", "марта", "апреля", "мая", "июня", "июля", "августа", "сентября", "октября", "ноября", "декабря"},
+	ShortMonths: [12]string{"янв", "фев", "мар", "апр", "мая", "июн", "июл", "авг", "сен", "окт", "ноя", "дек"},
+	Days:        [7]string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"},
+	ShortDays:   [7]string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"},
+}
+
+// WithTimeLocation sets the zone of times in emails, by default the zone of the entry.
+func WithTimeLocation(location *time.Location) MailOption {
+	return func(o *mailOptions) error {
+		if location == nil {
+			return errors.New("time location must not be nil")
+		}
+		o.timeLocation = location
+		return nil
+	}
+}
+
+// WithTimeNames translates the month and weekday names of the time layout, see WithTimeFormat.
+func WithTimeNames(names TimeNames) MailOption {
+	return func(o *mailOptions) error {
+		o.timeNames = &names
+		return nil
+	}
+}
+
+// layoutNames are the name elements of time layouts, longer ones first.
+var layoutNames = []string{"January", "Jan", "Monday", "Mon"}
+
+// format formats t like t.Format(layout) with the names translated.
+func (names *TimeNames) format(t time.Time, layout string) string {
+	var out strings.Builder
+	for layout != "" {
+		// Find the next name element, the parts between them are formatted as usual.
+		next, element := len(layout), ""
+		for _, name := range layoutNames {
+			if i := strings.Index(layout, name); i >= 0 && i < next {
+				next, element = i, name
+			}
+		}
+		out.WriteString(t.Format(layout[:next]))
+		if element == "" {
+			break
+		}
+		out.WriteString(names.name(t, element))
+		layout = layout[next+len(element):]
+	}
+	return out.String()
+}
+
+func (names *TimeNames) name(t time.Time, element string) string {
+	var name string
+	switch element {
+	case "January":
+		name = names.Months[t.Month()-1]
+	case "Jan":
+		name = names.ShortMonths[t.Month()-1]
+	case "Monday":
+		name = names.Days[t.Weekday()]
+	case "Mon":
+		name = names.ShortDays[t.Weekday()]
+	}
+	if name == "" {
+		return t.Format(element)
+	}
+	return name
+}
@@ -0,0 +1,192 @@
+package log_hooks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTLSHook(t *testing.T, server *testServer, opts ...MailOption) *MailHook {
+	t.Helper()
+	opts = append([]MailOption{WithDialer(redirectDialer{addr: server.ln.Addr().String()})}, opts...)
+	hook, err := NewMailHook("app", "mail.test", server.port(), "alerts@example.com", "ops@example.com", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = hook.Close() })
+	return hook
+}
+
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.startTLS = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+	})
+	// ServerName defaults to the host of the hook, mail.test.
+	hook := newTLSHook(t, server, WithStartTLS(&tls.Config{RootCAs: ca.pool}))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "over tls")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	if !slices.Contains(server.commandLog(), "STARTTLS") {
+		t.Fatalf("no STARTTLS in %q", server.commandLog())
+	}
+}
+
+func TestStartTLSUnknownAuthority(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.startTLS = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+	})
+	hook := newTLSHook(t, server, WithStartTLS(nil))
+
+	var unknown x509.UnknownAuthorityError
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "untrusted")); !errors.As(err, &unknown) {
+		t.Fatalf("got %v, want an unknown authority error", err)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages, want none", n)
+	}
+}
+
+func TestStartTLSServerName(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.startTLS = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+	})
+	hook := newTLSHook(t, server, WithStartTLS(&tls.Config{RootCAs: ca.pool, ServerName: "other.test"}))
+
+	var hostname x509.HostnameError
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "wrong name")); !errors.As(err, &hostname) {
+		t.Fatalf("got %v, want a hostname error", err)
+	}
+}
+
+func TestStartTLSNotSupported(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook := newTLSHook(t, server, WithStartTLS(nil))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "plaintext")); !errors.Is(err, ErrStartTLSNotSupported) {
+		t.Fatalf("got %v, want ErrStartTLSNotSupported", err)
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("got %d messages, want none", n)
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.implicit = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+	})
+	hook := newTLSHook(t, server, WithImplicitTLS(&tls.Config{RootCAs: ca.pool}))
+
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "over smtps")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	if slices.Contains(server.commandLog(), "STARTTLS") {
+		t.Fatalf("STARTTLS sent over implicit TLS: %q", server.commandLog())
+	}
+}
+
+func TestImplicitTLSUnknownAuthority(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.implicit = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+	})
+	hook := newTLSHook(t, server, WithImplicitTLS(nil))
+
+	var unknown x509.UnknownAuthorityError
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "untrusted")); !errors.As(err, &unknown) {
+		t.Fatalf("got %v, want an unknown authority error", err)
+	}
+}
+
+func TestConflictingTLSOptions(t *testing.T) {
+	_, err := NewMailHook("app", "mail.test", 465, "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithStartTLS(nil), WithImplicitTLS(nil))
+	if !errors.Is(err, ErrConflictingTLSOptions) {
+		t.Fatalf("got %v, want ErrConflictingTLSOptions", err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.server(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	clientConfig := &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.client(t, "alerts client")}}
+
+	for name, tc := range map[string]struct {
+		configure func(s *testServer)
+		option    MailOption
+	}{
+		"starttls": {func(s *testServer) { s.startTLS = serverConfig }, WithStartTLS(clientConfig)},
+		"implicit": {func(s *testServer) { s.implicit = serverConfig }, WithImplicitTLS(clientConfig)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t, tc.configure)
+			hook := newTLSHook(t, server, tc.option)
+
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, "mutual tls")); err != nil {
+				t.Fatal(err)
+			}
+			if peers := server.clientCertificates(); !slices.Equal(peers, []string{"alerts client"}) {
+				t.Fatalf("got client certificates %q", peers)
+			}
+		})
+	}
+}
+
+func TestClientCertificateRejected(t *testing.T) {
+	ca := newTestCA(t)
+	foreign := newTestCA(t).client(t, "stranger")
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		serverConfig := &tls.Config{
+			Certificates: []tls.Certificate{ca.server(t)},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.pool,
+			MaxVersion:   version,
+		}
+		clientConfig := &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{foreign}}
+
+		t.Run(tls.VersionName(version)+" starttls", func(t *testing.T) {
+			server := newTestServer(t, func(s *testServer) { s.startTLS = serverConfig })
+			hook := newTLSHook(t, server, WithStartTLS(clientConfig))
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, "rejected")); !errors.Is(err, ErrTLSClientAuth) {
+				t.Fatalf("got %v, want ErrTLSClientAuth", err)
+			}
+		})
+		t.Run(tls.VersionName(version)+" implicit", func(t *testing.T) {
+			server := newTestServer(t, func(s *testServer) { s.implicit = serverConfig })
+			hook := newTLSHook(t, server, WithImplicitTLS(clientConfig))
+			if err := hook.Fire(testEntry(logrus.ErrorLevel, "rejected")); !errors.Is(err, ErrTLSClientAuth) {
+				t.Fatalf("got %v, want ErrTLSClientAuth", err)
+			}
+		})
+	}
+}
+
+func TestClientCertificateUnreachable(t *testing.T) {
+	server := newTestServer(t, nil)
+	hook := newTLSHook(t, server, WithImplicitTLS(nil))
+	_ = server.ln.Close()
+
+	err := hook.Fire(testEntry(logrus.ErrorLevel, "unreachable"))
+	if err == nil || errors.Is(err, ErrTLSClientAuth) {
+		t.Fatalf("got %v, want a connection error", err)
+	}
+}
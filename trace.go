@@ -0,0 +1,248 @@
+package log_hooks
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// maxTranscriptSize bounds the transcript attached to an error.
+const maxTranscriptSize = 64 << 10
+
+// WithSMTPTrace records the SMTP conversation with the commands prefixed by "C: " and the
+// replies by "S: ". AUTH credentials are redacted and the message itself is replaced by its
+// size. The transcript is written to w, unless w is nil, and the transcript of a failed
+// send is attached to its error as *SMTPTranscriptError.
+func WithSMTPTrace(w io.Writer) MailOption {
+	return func(o *mailOptions) error {
+		o.trace = true
+		o.traceWriter = w
+		return nil
+	}
+}
+
+// SMTPTranscriptError is a send error together with the SMTP conversation that led to it.
+type SMTPTranscriptError struct {
+	Err        error
+	Transcript string
+}
+
+func (e *SMTPTranscriptError) Error() string {
+	return e.Err.Error() + "\nsmtp transcript:\n" + e.Transcript
+}
+
+func (e *SMTPTranscriptError) Unwrap() error {
+	return e.Err
+}
+
+// smtpTrace records the conversation of one session line by line.
+type smtpTrace struct {
+	mu sync.Mutex
+	w  io.Writer
+	// buf holds the transcript since the last reset.
+	buf bytes.Buffer
+
+	// partial lines by direction.
+	client, server []byte
+	// raw is cleared once STARTTLS succeeds, the connection then only carries ciphertext.
+	raw bool
+
+	lastCommand string
+	auth        bool
+	data        bool
+	dataSize    int
+}
+
+// newTrace returns nil unless tracing is enabled.
+func (o *mailOptions) newTrace(addr string) *smtpTrace {
+	if !o.trace {
+		return nil
+	}
+	t := &smtpTrace{w: o.traceWriter, raw: true}
+	t.emit("* connected to " + addr)
+	return t
+}
+
+// reset starts the transcript of a new send over the session.
+func (t *smtpTrace) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Reset()
+}
+
+// attach wraps err with the transcript.
+func (t *smtpTrace) attach(err error) error {
+	if t == nil || err == nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &SMTPTranscriptError{Err: err, Transcript: t.buf.String()}
+}
+
+// wrapConn traces the plaintext conversation before STARTTLS, or all of it with implicit TLS.
+func (t *smtpTrace) wrapConn(conn net.Conn) net.Conn {
+	if t == nil {
+		return conn
+	}
+	return &traceConn{Conn: conn, trace: t}
+}
+
+// wrapClient traces the conversation after STARTTLS by wrapping the buffers of the text connection.
+func (t *smtpTrace) wrapClient(client *smtp.Client) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.emit("* TLS started")
+	t.mu.Unlock()
+	client.Text.Reader.R = bufio.NewReader(&traceReader{r: client.Text.Reader.R, trace: t})
+	client.Text.Writer.W = bufio.NewWriter(&traceWriter{w: client.Text.Writer.W, trace: t})
+}
+
+func (t *smtpTrace) feed(fromClient bool, p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	partial := &t.server
+	if fromClient {
+		partial = &t.client
+	}
+	*partial = append(*partial, p...)
+	for {
+		i := bytes.IndexByte(*partial, '\n')
+		if i < 0 {
+			return
+		}
+		line := strings.TrimRight(string((*partial)[:i]), "\r")
+		*partial = (*partial)[i+1:]
+		if fromClient {
+			t.clientLine(line)
+		} else {
+			t.serverLine(line)
+		}
+	}
+}
+
+func (t *smtpTrace) clientLine(line string) {
+	switch {
+	case t.data:
+		if line == "." {
+			t.emit(fmt.Sprintf("C: [%d bytes of message data]", t.dataSize))
+			t.emit("C: .")
+			t.data = false
+			return
+		}
+		t.dataSize += len(line) + 2
+		return
+	case t.auth:
+		t.emit("C: [redacted]")
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		t.lastCommand = strings.ToUpper(fields[0])
+	}
+	if t.lastCommand == "AUTH" {
+		t.auth = true
+		if len(fields) > 2 {
+			line = strings.Join(fields[:2], " ") + " [redacted]"
+		}
+	}
+	t.emit("C: " + line)
+}
+
+func (t *smtpTrace) serverLine(line string) {
+	t.emit("S: " + line)
+	if len(line) < 4 || line[3] == '-' {
+		return
+	}
+	code := line[:3]
+	// The exchange of an AUTH command lasts while the server sends challenges.
+	if t.auth {
+		t.auth = code == "334"
+	}
+	switch t.lastCommand {
+	case "DATA":
+		t.data = code == "354"
+		t.dataSize = 0
+	case "STARTTLS":
+		if code == "220" {
+			t.raw = false
+		}
+	}
+	t.lastCommand = ""
+}
+
+// emit writes a line, the caller holds mu once the trace is in use.
+func (t *smtpTrace) emit(line string) {
+	if t.buf.Len() < maxTranscriptSize {
+		t.buf.WriteString(line + "\n")
+	}
+	if t.w != nil {
+		_, _ = io.WriteString(t.w, line+"\n")
+	}
+}
+
+// tracing reports whether the connection still carries plaintext.
+func (t *smtpTrace) tracing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.raw
+}
+
+type traceConn struct {
+	net.Conn
+	trace *smtpTrace
+}
+
+func (c *traceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.trace.tracing() {
+		c.trace.feed(false, p[:n])
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(p []byte) (int, error) {
+	if c.trace.tracing() {
+		c.trace.feed(true, p)
+	}
+	return c.Conn.Write(p)
+}
+
+type traceReader struct {
+	r     io.Reader
+	trace *smtpTrace
+}
+
+func (r *traceReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.trace.feed(false, p[:n])
+	}
+	return n, err
+}
+
+// traceWriter writes through to the buffered writer of the text connection.
+type traceWriter struct {
+	w     *bufio.Writer
+	trace *smtpTrace
+}
+
+func (w *traceWriter) Write(p []byte) (int, error) {
+	w.trace.feed(true, p)
+	n, err := w.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.w.Flush()
+}
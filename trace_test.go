@@ -0,0 +1,101 @@
+package log_hooks
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+var dataLine = regexp.MustCompile(`(?m)^C: \[(\d+) bytes of message data\]$`)
+
+func TestSMTPTrace(t *testing.T) {
+	server := newTestServer(t, func(s *testServer) {
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	for _, mechanism := range []AuthMechanism{AuthPlain, AuthLogin} {
+		var out bytes.Buffer
+		hook := newAuthHook(t, server, "alerts", "secret", WithAuthMechanism(mechanism), WithSMTPTrace(&out))
+		if err := hook.Fire(testEntry(logrus.ErrorLevel, "traced secret")); err != nil {
+			t.Fatal(err)
+		}
+		transcript := out.String()
+
+		credentials := []string{"secret", base64.StdEncoding.EncodeToString([]byte("\x00alerts\x00secret")),
+			base64.StdEncoding.EncodeToString([]byte("alerts")), base64.StdEncoding.EncodeToString([]byte("secret"))}
+		for _, credential := range credentials {
+			if strings.Contains(transcript, credential) {
+				t.Fatalf("%s: transcript holds %q:\n%s", mechanism, credential, transcript)
+			}
+		}
+		want := []string{"C: AUTH PLAIN [redacted]", "S: 235"}
+		if mechanism == AuthLogin {
+			want = []string{"C: AUTH LOGIN\n", "S: 334", "C: [redacted]\nS: 334", "C: [redacted]\nS: 235"}
+		}
+		for _, line := range append(want, "* connected to 127.0.0.1:", "C: MAIL FROM:<alerts@example.com>", "C: DATA", "S: 354", "C: .\nS: 250") {
+			if !strings.Contains(transcript, line) {
+				t.Fatalf("%s: no %q in transcript:\n%s", mechanism, line, transcript)
+			}
+		}
+		if match := dataLine.FindStringSubmatch(transcript); match == nil || match[1] == "0" || strings.Contains(transcript, "MESSAGE: ") {
+			t.Fatalf("%s: the message isn't replaced by its size:\n%s", mechanism, transcript)
+		}
+	}
+}
+
+func TestSMTPTraceStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestServer(t, func(s *testServer) {
+		s.startTLS = &tls.Config{Certificates: []tls.Certificate{ca.server(t)}}
+		s.users = map[string]string{"alerts": "secret"}
+	})
+	var out bytes.Buffer
+	hook := newAuthHook(t, server, "alerts", "secret", WithTLSConfig(&tls.Config{RootCAs: ca.pool, ServerName: "mail.test"}),
+		WithSMTPTrace(&out))
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "encrypted")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The conversation after STARTTLS is traced in plaintext, not as ciphertext.
+	transcript := out.String()
+	before, after, ok := strings.Cut(transcript, "* TLS started\n")
+	if !ok || !strings.Contains(before, "C: STARTTLS\nS: 220") || !strings.Contains(after, "C: AUTH PLAIN [redacted]") ||
+		!dataLine.MatchString(after) || strings.Contains(transcript, "secret") {
+		t.Fatalf("got transcript:\n%s", transcript)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(transcript), "\n") {
+		if !strings.HasPrefix(line, "C: ") && !strings.HasPrefix(line, "S: ") && !strings.HasPrefix(line, "* ") {
+			t.Fatalf("got line %q in transcript:\n%s", line, transcript)
+		}
+	}
+}
+
+func TestSMTPTranscriptError(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.rejectData.Store(1)
+	hook, err := NewMailHook("app", "127.0.0.1", server.port(), "alerts@example.com", "ops@example.com",
+		WithoutConnectivityCheck(), WithSMTPTrace(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hook.Fire(testEntry(logrus.ErrorLevel, "rejected"))
+	var transcriptErr *SMTPTranscriptError
+	if !errors.As(err, &transcriptErr) {
+		t.Fatalf("got %v, want an SMTPTranscriptError", err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(transcriptErr.Transcript), "C: .\nS: 554 transaction failed") ||
+		!strings.Contains(err.Error(), "\nsmtp transcript:\n") {
+		t.Fatalf("got transcript:\n%s", transcriptErr.Transcript)
+	}
+
+	// The transcript of a later send over the session starts afresh.
+	if err := hook.Fire(testEntry(logrus.ErrorLevel, "accepted")); err != nil {
+		t.Fatal(err)
+	}
+}